@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert rules live in alerts.json next to config.json:
+//
+//	{"rules": [{"name": "cpu_hot", "expr": "cpu > 90 for 5m", "severity": "warning"}]}
+//
+// An expression compares a history metric, or the per-second rate of any
+// other one with a "_rate" suffix, against a threshold. Rules are evaluated on every
+// stats sample and move through pending, firing and resolved. A rule whose
+// metric stops being reported is marked noData and resolved after
+// alertNoDataTimeout.
+
+const (
+	alertInactive = "inactive"
+	alertPending  = "pending"
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	maxAlertEvents     = 100
+	alertNoDataTimeout = 5 * time.Minute
+)
+
+var alertExprPattern = regexp.MustCompile(`^\s*([a-z0-9_]+)\s*(>=|<=|==|!=|>|<)\s*(-?[0-9]+(?:\.[0-9]+)?)\s*(/s|%)?\s*(?:for\s+(\S+))?\s*$`)
+
+type alertDefinition struct {
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	Severity    string `json:"severity,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type alertsFile struct {
+	Rules []alertDefinition `json:"rules"`
+}
+
+// AlertStatus is the current state of one rule as served by /api/alerts.
+type AlertStatus struct {
+	alertDefinition
+	State       string   `json:"state"`
+	Value       *float64 `json:"value,omitempty"`
+	ActiveSince int64    `json:"activeSince,omitempty"`
+	FiredAt     int64    `json:"firedAt,omitempty"`
+	ResolvedAt  int64    `json:"resolvedAt,omitempty"`
+	LastSeen    int64    `json:"lastSeen,omitempty"`
+	NoData      bool     `json:"noData,omitempty"`
+}
+
+// AlertEvent is one state transition.
+type AlertEvent struct {
+	Rule      string  `json:"rule"`
+	Severity  string  `json:"severity,omitempty"`
+	Expr      string  `json:"expr"`
+	State     string  `json:"state"`
+	Previous  string  `json:"previous"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Time      int64   `json:"time"`
+	NoData    bool    `json:"noData,omitempty"`
+}
+
+type alertRule struct {
+	status    AlertStatus
+	metric    string
+	rate      bool
+	op        string
+	threshold float64
+	hold      time.Duration
+}
+
+func parseAlertRule(def alertDefinition) (*alertRule, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("rule without name")
+	}
+	m := alertExprPattern.FindStringSubmatch(def.Expr)
+	if m == nil {
+		return nil, fmt.Errorf("%s: cannot parse %q, expected \"<metric> <op> <number> [for <duration>]\"", def.Name, def.Expr)
+	}
+
+	rule := &alertRule{status: AlertStatus{alertDefinition: def, State: alertInactive}, metric: m[1], op: m[2]}
+	if _, ok := historyMetrics[rule.metric]; !ok {
+		base := strings.TrimSuffix(rule.metric, "_rate")
+		if _, ok := historyMetrics[base]; !ok || base == rule.metric {
+			return nil, fmt.Errorf("%s: unknown metric %s", def.Name, rule.metric)
+		}
+		rule.metric, rule.rate = base, true
+	}
+	if m[4] == "/s" && !strings.HasSuffix(m[1], "_rate") {
+		return nil, fmt.Errorf("%s: /s only applies to _rate metrics", def.Name)
+	}
+
+	rule.threshold, _ = strconv.ParseFloat(m[3], 64)
+	if m[5] != "" {
+		hold, err := time.ParseDuration(m[5])
+		if err != nil || hold < 0 {
+			return nil, fmt.Errorf("%s: invalid duration %q", def.Name, m[5])
+		}
+		rule.hold = hold
+	}
+	return rule, nil
+}
+
+func (r *alertRule) matches(v float64) bool {
+	switch r.op {
+	case ">":
+		return v > r.threshold
+	case ">=":
+		return v >= r.threshold
+	case "<":
+		return v < r.threshold
+	case "<=":
+		return v <= r.threshold
+	case "==":
+		return v == r.threshold
+	default:
+		return v != r.threshold
+	}
+}
+
+type metricSample struct {
+	value float64
+	time  int64
+}
+
+type alertEngine struct {
+	mu       sync.Mutex
+	path     string
+	modTime  time.Time
+	size     int64
+	loadErr  error
+	rules    []*alertRule
+	previous map[string]metricSample
+	events   []AlertEvent
+	subs     map[chan AlertEvent]struct{}
+}
+
+func newAlertEngine(path string) *alertEngine {
+	return &alertEngine{
+		path:     path,
+		previous: make(map[string]metricSample),
+		subs:     make(map[chan AlertEvent]struct{}),
+	}
+}
+
+func (d *Dashboard) alertsPath() string {
+	return d.path(filepath.Join(filepath.Dir(configFile), "alerts.json"))
+}
+
+// reloadIfChanged re-reads the rules file when its stamp changes. Rules
+// keep their state across reloads as long as name and expression match;
+// a broken file keeps the previous rules.
+func (e *alertEngine) reloadIfChanged() {
+	info, err := os.Stat(e.path)
+	if os.IsNotExist(err) {
+		if e.rules != nil || e.loadErr != nil {
+			slog.Info("Alert rules removed", "file", e.path)
+		}
+		e.rules, e.loadErr, e.modTime, e.size = nil, nil, time.Time{}, 0
+		return
+	}
+	if err != nil {
+		e.loadErr = err
+		return
+	}
+	if info.ModTime().Equal(e.modTime) && info.Size() == e.size {
+		return
+	}
+	e.modTime, e.size = info.ModTime(), info.Size()
+
+	rules, err := loadAlertRules(e.path)
+	if err != nil {
+		e.loadErr = err
+		slog.Error("Failed to load alert rules", "file", e.path, "error", err)
+		return
+	}
+
+	old := make(map[string]*alertRule)
+	for _, r := range e.rules {
+		old[r.status.Name] = r
+	}
+	for _, r := range rules {
+		if prev, ok := old[r.status.Name]; ok && prev.status.Expr == r.status.Expr {
+			def := r.status.alertDefinition
+			r.status = prev.status
+			r.status.alertDefinition = def
+		}
+	}
+	e.rules, e.loadErr = rules, nil
+	slog.Info("Alert rules loaded", "file", e.path, "rules", len(rules))
+}
+
+func loadAlertRules(path string) ([]*alertRule, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file alertsFile
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	var rules []*alertRule
+	seen := make(map[string]bool)
+	for _, def := range file.Rules {
+		rule, err := parseAlertRule(def)
+		if err != nil {
+			return nil, err
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("duplicate rule %s", def.Name)
+		}
+		seen[def.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// evaluate runs every rule against one sample and broadcasts transitions.
+func (e *alertEngine) evaluate(sample *historySample) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.reloadIfChanged()
+
+	values := make(map[string]float64)
+	rates := make(map[string]float64)
+	for i, name := range historyColumns {
+		v := sample.Values[i]
+		if math.IsNaN(v) {
+			continue
+		}
+		values[name] = v
+		// Rates treat metrics as counters: a drop is a reset, not a rate.
+		if prev, ok := e.previous[name]; ok && sample.Time > prev.time && v >= prev.value {
+			rates[name] = (v - prev.value) / float64(sample.Time-prev.time)
+		}
+		e.previous[name] = metricSample{value: v, time: sample.Time}
+	}
+
+	for _, rule := range e.rules {
+		source := values
+		if rule.rate {
+			source = rates
+		}
+		v, ok := source[rule.metric]
+		if !ok {
+			e.missing(rule, sample.Time)
+			continue
+		}
+		value := v
+		rule.status.Value = &value
+		e.step(rule, v, sample.Time)
+	}
+}
+
+func (e *alertEngine) step(rule *alertRule, v float64, now int64) {
+	s := &rule.status
+	previous := s.State
+	s.LastSeen, s.NoData = now, false
+
+	if rule.matches(v) {
+		switch s.State {
+		case alertInactive, alertResolved:
+			s.ActiveSince = now
+			s.State = alertPending
+			if rule.hold == 0 {
+				s.State = alertFiring
+				s.FiredAt = now
+			}
+		case alertPending:
+			if time.Duration(now-s.ActiveSince)*time.Second >= rule.hold {
+				s.State = alertFiring
+				s.FiredAt = now
+			}
+		}
+	} else {
+		switch s.State {
+		case alertPending:
+			s.State = alertInactive
+			s.ActiveSince = 0
+		case alertFiring:
+			s.State = alertResolved
+			s.ResolvedAt = now
+		}
+	}
+
+	if s.State == previous {
+		return
+	}
+	e.emit(rule, previous, v, now)
+}
+
+// missing handles a sample without the rule's metric. The rule keeps its
+// state for alertNoDataTimeout after the last value, then pending goes
+// back to inactive and firing resolves.
+func (e *alertEngine) missing(rule *alertRule, now int64) {
+	s := &rule.status
+	if s.NoData || s.LastSeen == 0 || time.Duration(now-s.LastSeen)*time.Second < alertNoDataTimeout {
+		return
+	}
+	s.NoData = true
+	slog.Warn("Alert metric missing", "rule", s.Name, "expr", s.Expr, "since", s.LastSeen)
+
+	previous := s.State
+	switch s.State {
+	case alertPending:
+		s.State = alertInactive
+		s.ActiveSince = 0
+	case alertFiring:
+		s.State = alertResolved
+		s.ResolvedAt = now
+	}
+	if s.State != previous {
+		e.emit(rule, previous, *s.Value, now)
+	}
+}
+
+func (e *alertEngine) emit(rule *alertRule, previous string, v float64, now int64) {
+	s := &rule.status
+	event := AlertEvent{
+		Rule:      s.Name,
+		Severity:  s.Severity,
+		Expr:      s.Expr,
+		State:     s.State,
+		Previous:  previous,
+		Value:     v,
+		Threshold: rule.threshold,
+		Time:      now,
+		NoData:    s.NoData,
+	}
+	switch s.State {
+	case alertFiring:
+		slog.Warn("Alert firing", "rule", s.Name, "expr", s.Expr, "value", v)
+	case alertResolved:
+		slog.Info("Alert resolved", "rule", s.Name, "expr", s.Expr, "value", v)
+	}
+
+	e.events = append(e.events, event)
+	if len(e.events) > maxAlertEvents {
+		e.events = e.events[len(e.events)-maxAlertEvents:]
+	}
+	for ch := range e.subs {
+		select {
+		case ch <- event:
+		default:
+			slog.Debug("Dropping alert event for slow client", "rule", s.Name)
+		}
+	}
+}
+
+func (e *alertEngine) snapshot() ([]AlertStatus, []AlertEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]AlertStatus, 0, len(e.rules))
+	for _, r := range e.rules {
+		statuses = append(statuses, r.status)
+	}
+	events := append([]AlertEvent{}, e.events...)
+	return statuses, events, e.loadErr
+}
+
+func (e *alertEngine) Subscribe() chan AlertEvent {
+	ch := make(chan AlertEvent, 16)
+	e.mu.Lock()
+	e.subs[ch] = struct{}{}
+	e.mu.Unlock()
+	return ch
+}
+
+func (e *alertEngine) Subscribers() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.subs)
+}
+
+func (e *alertEngine) Unsubscribe(ch chan AlertEvent) {
+	e.mu.Lock()
+	delete(e.subs, ch)
+	e.mu.Unlock()
+}
+
+func (d *Dashboard) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	statuses, events, err := d.alerts.snapshot()
+	response := map[string]interface{}{
+		"success": true,
+		"file":    d.alerts.path,
+		"alerts":  statuses,
+		"events":  events,
+	}
+	if err != nil {
+		response["error"] = "Ошибка в файле правил: " + err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// wsAlertsHandler sends the current alert states once and then every
+// transition as it happens.
+func (d *Dashboard) wsAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := d.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("WebSocket upgrade failed", "path", r.URL.Path, "error", err)
+		return
+	}
+	d.wg.Add(1)
+	defer d.wg.Done()
+
+	events := d.alerts.Subscribe()
+	defer d.alerts.Unsubscribe(events)
+
+	statuses, _, _ := d.alerts.snapshot()
+	if err := conn.WriteJSON(map[string]interface{}{"type": "alerts", "alerts": statuses}); err != nil {
+		conn.Close()
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var event AlertEvent
+		select {
+		case <-d.ctx.Done():
+			closeWebSocket(conn)
+			return
+		case <-closed:
+			conn.Close()
+			return
+		case event = <-events:
+		}
+
+		if err := conn.WriteJSON(map[string]interface{}{"type": "alert", "event": event}); err != nil {
+			slog.Debug("Alerts client disconnected", "client", clientIP(r), "error", err)
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	tests := []struct {
+		expr      string
+		metric    string
+		rate      bool
+		op        string
+		threshold float64
+		hold      time.Duration
+		ok        bool
+	}{
+		{"cpu > 90 for 5m", "cpu", false, ">", 90, 5 * time.Minute, true},
+		{"blocked_rate > 1000/s", "blocked_rate", false, ">", 1000, 0, true},
+		{"ram_used_rate > 1000000/s", "ram_used", true, ">", 1000000, 0, true},
+		{"ram>=95%", "ram", false, ">=", 95, 0, true},
+		{"temp_max < -5.5 for 30s", "temp_max", false, "<", -5.5, 30 * time.Second, true},
+		{"cpu > 90/s", "", false, "", 0, 0, false},
+		{"nope > 1", "", false, "", 0, 0, false},
+		{"cpu_rate_rate > 1", "", false, "", 0, 0, false},
+		{"cpu > 90 for soon", "", false, "", 0, 0, false},
+		{"cpu is high", "", false, "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		rule, err := parseAlertRule(alertDefinition{Name: "r", Expr: tt.expr})
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: err = %v", tt.expr, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if rule.metric != tt.metric || rule.rate != tt.rate || rule.op != tt.op || rule.threshold != tt.threshold || rule.hold != tt.hold {
+			t.Errorf("%q: got %+v", tt.expr, rule)
+		}
+	}
+}
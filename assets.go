@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed static
+var embeddedStatic embed.FS
+
+const (
+	pageCacheControl  = "no-cache"
+	assetCacheControl = "public, max-age=3600"
+)
+
+var compressibleExts = map[string]bool{
+	".html": true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".svg":  true,
+	".txt":  true,
+}
+
+type asset struct {
+	data     []byte
+	gzipData []byte
+	etag     string
+	gzipEtag string
+}
+
+// assetServer serves the web UI from the assets embedded into the binary,
+// or straight from dir when AppConfig.StaticDir is set.
+type assetServer struct {
+	dir    string
+	assets map[string]*asset
+}
+
+func newAssetServer(dir string) (*assetServer, error) {
+	if dir != "" {
+		return &assetServer{dir: dir}, nil
+	}
+
+	root, err := fs.Sub(embeddedStatic, "static")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &assetServer{assets: make(map[string]*asset)}
+	err = fs.WalkDir(root, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(root, name)
+		if err != nil {
+			return err
+		}
+		a.assets[name] = newAsset(name, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func newAsset(name string, data []byte) *asset {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+
+	a := &asset{data: data, etag: `"` + hash + `"`}
+	if compressibleExts[path.Ext(name)] {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(data)
+		zw.Close()
+		if buf.Len() < len(data) {
+			a.gzipData = buf.Bytes()
+			a.gzipEtag = `"` + hash + `-gz"`
+		}
+	}
+	return a
+}
+
+func (a *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	a.serve(w, r, name, assetCacheControl)
+}
+
+func (a *assetServer) servePage(w http.ResponseWriter, r *http.Request, name string) {
+	a.serve(w, r, name, pageCacheControl)
+}
+
+func (a *assetServer) serve(w http.ResponseWriter, r *http.Request, name, cacheControl string) {
+	if a.dir != "" {
+		a.serveDir(w, r, name)
+		return
+	}
+
+	asset, ok := a.assets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+
+	data, etag := asset.data, asset.etag
+	if asset.gzipData != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) && r.Header.Get("Range") == "" {
+			data, etag = asset.gzipData, asset.gzipEtag
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("ETag", etag)
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+func (a *assetServer) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := http.Dir(a.dir).Open("/" + name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func checkStaticDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "stat", Path: dir, Err: fs.ErrInvalid}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		return err
+	}
+	return nil
+}
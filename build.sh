@@ -24,8 +24,8 @@ mkdir -p $BUILD_DIR
 go build -ldflags="-w -s" -o $BUILD_DIR/gex-dashboard .
 
 # Копируем дополнительные файлы
+# Веб-интерфейс встроен в исполнимый файл
 cp install.sh $BUILD_DIR/
-cp -r static $BUILD_DIR/static
 cp README.md $BUILD_DIR/ 2>/dev/null || true
 chmod +x $BUILD_DIR/install.sh
 
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type AppConfig struct {
+	Version         int      `json:"version"`
+	NFQ_LOG_FILE    string   `json:"nfq_log_file"`
+	NFQ_CONFIG_FILE string   `json:"nfq_config_file"`
+	NFQ_RULES_DIR   string   `json:"nfq_rules_dir"`
+	NET_STATS_FILE  string   `json:"net_stats_file"`
+	SYS_STATS_FILE  string   `json:"sys_stats_file"`
+	LogLevel        string   `json:"logLevel"`
+	LogFormat       string   `json:"logFormat,omitempty"`
+	Interface       string   `json:"interface"`
+	ListenAddress   string   `json:"listenAddress"`
+	ListenPort      string   `json:"listenPort"`
+	Listeners       []string `json:"listeners,omitempty"`
+	SocketMode      string   `json:"socketMode,omitempty"`
+	StaticDir       string   `json:"staticDir,omitempty"`
+	MetricsDir      string   `json:"metricsDir,omitempty"`
+	Mounts          []string `json:"mounts,omitempty"`
+	DiskWarnPercent int      `json:"diskWarnPercent,omitempty"`
+	StatsStaleAfter string   `json:"statsStaleAfter,omitempty"`
+}
+
+var currentConfig atomic.Pointer[AppConfig]
+
+func getConfig() *AppConfig {
+	return currentConfig.Load()
+}
+
+func setConfig(cfg *AppConfig) *AppConfig {
+	return currentConfig.Swap(cfg)
+}
+
+const CONFIG_FILE = "./config.json"
+
+var configFile = CONFIG_FILE
+
+// readConfig reads and migrates the config file in memory. needsSave
+// reports that the file is missing or in an old format; nothing is written.
+func readConfig(path string) (cfg *AppConfig, needsSave bool, err error) {
+	defaultConfig := &AppConfig{
+		Version:         configVersion,
+		NFQ_LOG_FILE:    "/root/nfq/log.txt",
+		NFQ_CONFIG_FILE: "/root/nfq/config.json",
+		NFQ_RULES_DIR:   "/root/nfq/rules",
+		NET_STATS_FILE:  "/tmp/nfq/nfq.json",
+		SYS_STATS_FILE:  "/tmp/nfq/sys.json",
+		Interface:       "lan0",
+		LogLevel:        "info",
+		ListenPort:      "8080",
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return defaultConfig, true, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, false, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	migrated, err := migrateConfig(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	if problems := unknownConfigKeys(raw); len(problems) > 0 {
+		return nil, false, &configError{file: path, problems: problems}
+	}
+
+	content, err = json.Marshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cfg = &AppConfig{}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, false, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	if cfg.NFQ_LOG_FILE == "" {
+		cfg.NFQ_LOG_FILE = defaultConfig.NFQ_LOG_FILE
+	}
+	if cfg.NFQ_CONFIG_FILE == "" {
+		cfg.NFQ_CONFIG_FILE = defaultConfig.NFQ_CONFIG_FILE
+	}
+	if cfg.NFQ_RULES_DIR == "" {
+		cfg.NFQ_RULES_DIR = defaultConfig.NFQ_RULES_DIR
+	}
+	if cfg.NET_STATS_FILE == "" {
+		cfg.NET_STATS_FILE = defaultConfig.NET_STATS_FILE
+	}
+	if cfg.SYS_STATS_FILE == "" {
+		cfg.SYS_STATS_FILE = defaultConfig.SYS_STATS_FILE
+	}
+	if cfg.Interface == "" {
+		cfg.Interface = defaultConfig.Interface
+	}
+	if cfg.LogLevel == "" {
+		cfg.LogLevel = defaultConfig.LogLevel
+	}
+	if cfg.ListenPort == "" {
+		cfg.ListenPort = defaultConfig.ListenPort
+	}
+
+	return cfg, migrated, nil
+}
+
+// loadConfig reads the config file, creating a default one or rewriting a
+// migrated one in place.
+func loadConfig(path string) (*AppConfig, error) {
+	cfg, needsSave, err := readConfig(path)
+	if err != nil || !needsSave {
+		return cfg, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := saveConfig(path, cfg); err != nil {
+			return nil, fmt.Errorf("failed to create default config: %v", err)
+		}
+		return cfg, nil
+	}
+	if err := saveConfig(path, cfg); err != nil {
+		slog.Warn("Failed to save migrated config", "file", path, "error", err)
+	} else {
+		slog.Info("Config migrated", "file", path, "version", configVersion)
+	}
+	return cfg, nil
+}
+
+func saveConfig(path string, cfg *AppConfig) error {
+	configData, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, configData, 0644)
+}
+
+type configField struct {
+	key   string
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *AppConfig, value string)
+}
+
+var configFields = []configField{
+	{"nfq_log_file", "nfq-log-file", "GEX_NFQ_LOG_FILE", "путь к логу NFQ",
+		func(cfg *AppConfig, v string) { cfg.NFQ_LOG_FILE = v }},
+	{"nfq_config_file", "nfq-config-file", "GEX_NFQ_CONFIG_FILE", "путь к конфигурации NFQ",
+		func(cfg *AppConfig, v string) { cfg.NFQ_CONFIG_FILE = v }},
+	{"nfq_rules_dir", "nfq-rules-dir", "GEX_NFQ_RULES_DIR", "директория правил NFQ",
+		func(cfg *AppConfig, v string) { cfg.NFQ_RULES_DIR = v }},
+	{"net_stats_file", "net-stats-file", "GEX_NET_STATS_FILE", "файл статистики пакетов",
+		func(cfg *AppConfig, v string) { cfg.NET_STATS_FILE = v }},
+	{"sys_stats_file", "sys-stats-file", "GEX_SYS_STATS_FILE", "файл статистики движка",
+		func(cfg *AppConfig, v string) { cfg.SYS_STATS_FILE = v }},
+	{"logLevel", "log-level", "GEX_LOG_LEVEL", "уровень логирования",
+		func(cfg *AppConfig, v string) { cfg.LogLevel = v }},
+	{"logFormat", "log-format", "GEX_LOG_FORMAT", "формат логов: text или json",
+		func(cfg *AppConfig, v string) { cfg.LogFormat = v }},
+	{"interface", "interface", "GEX_INTERFACE", "сетевой интерфейс",
+		func(cfg *AppConfig, v string) { cfg.Interface = v }},
+	{"listenAddress", "listen-address", "GEX_LISTEN_ADDRESS", "адрес прослушивания",
+		func(cfg *AppConfig, v string) { cfg.ListenAddress = v }},
+	{"listenPort", "listen-port", "GEX_LISTEN_PORT", "порт прослушивания",
+		func(cfg *AppConfig, v string) { cfg.ListenPort = v }},
+	{"listeners", "listeners", "GEX_LISTENERS", "список адресов через запятую (host:port, unix:/path)",
+		func(cfg *AppConfig, v string) { cfg.Listeners = splitList(v) }},
+	{"socketMode", "socket-mode", "GEX_SOCKET_MODE", "права unix-сокетов из listeners, восьмеричные (по умолчанию 0660)",
+		func(cfg *AppConfig, v string) { cfg.SocketMode = v }},
+	{"staticDir", "static-dir", "GEX_STATIC_DIR", "раздавать веб-интерфейс из директории вместо встроенного",
+		func(cfg *AppConfig, v string) { cfg.StaticDir = v }},
+	{"metricsDir", "metrics-dir", "GEX_METRICS_DIR", "директория хранилища истории метрик",
+		func(cfg *AppConfig, v string) { cfg.MetricsDir = v }},
+	{"mounts", "mounts", "GEX_MOUNTS", "точки монтирования через запятую для статистики дисков",
+		func(cfg *AppConfig, v string) { cfg.Mounts = splitList(v) }},
+	{"diskWarnPercent", "disk-warn-percent", "GEX_DISK_WARN_PERCENT", "порог заполнения диска или inode для предупреждения, %",
+		func(cfg *AppConfig, v string) {
+			// An unparsable value is kept out of range so validateConfig reports it.
+			percent, err := strconv.Atoi(v)
+			if err != nil {
+				percent = -1
+			}
+			cfg.DiskWarnPercent = percent
+		}},
+	{"statsStaleAfter", "stats-stale-after", "GEX_STATS_STALE_AFTER", "через сколько файлы статистики NFQ считаются устаревшими",
+		func(cfg *AppConfig, v string) { cfg.StatsStaleAfter = v }},
+}
+
+type configOverrides struct {
+	configFile string
+	flags      map[string]string
+}
+
+func parseFlags(args []string) (*configOverrides, error) {
+	fs := flag.NewFlagSet("gex-dashboard", flag.ContinueOnError)
+
+	overrides := &configOverrides{flags: make(map[string]string)}
+
+	defaultConfigFile := CONFIG_FILE
+	if v, ok := os.LookupEnv("GEX_CONFIG"); ok && v != "" {
+		defaultConfigFile = v
+	}
+	fs.StringVar(&overrides.configFile, "config", defaultConfigFile, "путь к config.json (GEX_CONFIG)")
+
+	values := make(map[string]*string)
+	for _, f := range configFields {
+		values[f.flag] = fs.String(f.flag, "", f.usage+" ("+f.env+")")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[f.Name]; ok {
+			overrides.flags[f.Name] = *v
+		}
+	})
+
+	return overrides, nil
+}
+
+func applyOverrides(cfg *AppConfig, overrides *configOverrides) {
+	for _, f := range configFields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			f.set(cfg, v)
+		}
+	}
+	for _, f := range configFields {
+		if v, ok := overrides.flags[f.flag]; ok {
+			f.set(cfg, v)
+		}
+	}
+}
+
+// overriddenKeys maps config keys set from the environment or flags to the
+// variable or flag that set them.
+func overriddenKeys(overrides *configOverrides) map[string]string {
+	keys := make(map[string]string)
+	for _, f := range configFields {
+		if _, ok := os.LookupEnv(f.env); ok {
+			keys[f.key] = f.env
+		}
+	}
+	for _, f := range configFields {
+		if _, ok := overrides.flags[f.flag]; ok {
+			keys[f.key] = "--" + f.flag
+		}
+	}
+	return keys
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
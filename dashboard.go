@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type StatsProvider interface {
+	CPUPercent(interval time.Duration) (float64, error)
+	VirtualMemory() (*mem.VirtualMemoryStat, error)
+	DiskUsage(path string) (*disk.UsageStat, error)
+	DiskIOCounters() (map[string]disk.IOCountersStat, error)
+	NetIOCounters() ([]net.IOCountersStat, error)
+	Interfaces() (net.InterfaceStatList, error)
+	Temperatures() ([]host.TemperatureStat, error)
+	PerCoreCPUPercent() ([]float64, error)
+	LoadAvg() (*load.AvgStat, error)
+	Uptime() (uint64, error)
+	Process(pid int32) (*ProcessSample, error)
+}
+
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) error
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+type DashboardOptions struct {
+	// Root is prepended to every path from AppConfig; empty means "/".
+	Root      string
+	StaticDir string
+	Clock     Clock
+	Stats     StatsProvider
+	Commands  CommandRunner
+	Overrides *configOverrides
+	// StatsInterval is how often the shared stats sampler runs.
+	StatsInterval time.Duration
+}
+
+type Dashboard struct {
+	ctx           context.Context
+	wg            sync.WaitGroup
+	upgrader      websocket.Upgrader
+	logClients    map[*websocket.Conn]bool
+	logClientsMux sync.RWMutex
+	reloader      *configReloader
+	assets        *assetServer
+	logPath       string
+	lastLogSize   int64
+	logWatch      logWatchState
+
+	root     string
+	clock    Clock
+	stats    StatsProvider
+	commands CommandRunner
+
+	netStatsMux  sync.Mutex
+	prevNetStats map[string]NetworkStats
+	prevDiskIO   diskIOSample
+	processes    map[string]processTrack
+	units        map[string]unitState
+	unitsErr     error
+	unitsAt      time.Time
+	packetRates  packetRateTracker
+	statsHub     *hub[*SystemStats]
+	packetHub    *hub[*packetStatsMessage]
+	history      *metricsHistory
+	store        *metricsStore
+	alerts       *alertEngine
+}
+
+func NewDashboard(ctx context.Context, opts DashboardOptions) (*Dashboard, error) {
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.Stats == nil {
+		opts.Stats = gopsutilStats{}
+	}
+	if opts.Commands == nil {
+		opts.Commands = execRunner{}
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultStatsInterval
+	}
+	if opts.Overrides == nil {
+		opts.Overrides = &configOverrides{flags: make(map[string]string)}
+	}
+
+	d := &Dashboard{
+		ctx: ctx,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		logClients: make(map[*websocket.Conn]bool),
+		root:       opts.Root,
+		clock:      opts.Clock,
+		stats:      opts.Stats,
+		commands:   opts.Commands,
+		statsHub:   newHub[*SystemStats](),
+		packetHub:  newHub[*packetStatsMessage](),
+		processes:  make(map[string]processTrack),
+		history:    newMetricsHistory(int(historyRetention / opts.StatsInterval)),
+	}
+
+	assets, err := newAssetServer(opts.StaticDir)
+	if err != nil {
+		return nil, err
+	}
+	d.assets = assets
+
+	if err := d.resetNetStats(); err != nil {
+		return nil, err
+	}
+
+	d.openMetricsStore()
+	d.alerts = newAlertEngine(d.alertsPath())
+
+	d.reloader = newConfigReloader(opts.Overrides, d)
+	d.initLogWatcher()
+	d.wg.Add(1)
+	go d.watchPacketStats()
+
+	d.sampleStats()
+	d.wg.Add(1)
+	go d.runStatsSampler(opts.StatsInterval)
+
+	return d, nil
+}
+
+// path maps a path from AppConfig into the dashboard's filesystem root.
+func (d *Dashboard) path(p string) string {
+	if d.root == "" {
+		return p
+	}
+	return filepath.Join(d.root, p)
+}
+
+func (d *Dashboard) configPath() string {
+	return d.path(configFile)
+}
+
+func (d *Dashboard) rulePath(name string) string {
+	return filepath.Join(d.path(getConfig().NFQ_RULES_DIR), name+".json")
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type gopsutilStats struct{}
+
+func (gopsutilStats) CPUPercent(interval time.Duration) (float64, error) {
+	percent, err := cpu.Percent(interval, false)
+	if err != nil {
+		return 0, err
+	}
+	if len(percent) == 0 {
+		return 0, nil
+	}
+	return percent[0], nil
+}
+
+func (gopsutilStats) VirtualMemory() (*mem.VirtualMemoryStat, error) {
+	return mem.VirtualMemory()
+}
+
+func (gopsutilStats) DiskUsage(path string) (*disk.UsageStat, error) {
+	return disk.Usage(path)
+}
+
+func (gopsutilStats) DiskIOCounters() (map[string]disk.IOCountersStat, error) {
+	return disk.IOCounters()
+}
+
+func (gopsutilStats) NetIOCounters() ([]net.IOCountersStat, error) {
+	return net.IOCounters(true)
+}
+
+func (gopsutilStats) Interfaces() (net.InterfaceStatList, error) {
+	return net.Interfaces()
+}
+
+func (gopsutilStats) Temperatures() ([]host.TemperatureStat, error) {
+	return host.SensorsTemperatures()
+}
+
+// PerCoreCPUPercent reports usage per core since the previous call.
+func (gopsutilStats) PerCoreCPUPercent() ([]float64, error) {
+	return cpu.Percent(0, true)
+}
+
+func (gopsutilStats) LoadAvg() (*load.AvgStat, error) {
+	return load.Avg()
+}
+
+func (gopsutilStats) Uptime() (uint64, error) {
+	return host.Uptime()
+}
+
+func (gopsutilStats) Process(pid int32) (*ProcessSample, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	times, err := p.Times()
+	if err != nil {
+		return nil, err
+	}
+	memory, err := p.MemoryInfo()
+	if err != nil {
+		return nil, err
+	}
+	sample := &ProcessSample{
+		CPUTime: times.User + times.System,
+		RSS:     memory.RSS,
+		FDs:     -1,
+	}
+	if fds, err := p.NumFDs(); err == nil {
+		sample.FDs = fds
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		sample.Threads = threads
+	}
+	if created, err := p.CreateTime(); err == nil {
+		sample.CreateTime = time.UnixMilli(created)
+	}
+	return sample, nil
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) error {
+	return exec.CommandContext(ctx, name, args...).Run()
+}
+
+func (execRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// EngineQueueStats is how long packets wait in one NFQ queue before the
+// engine issues a verdict.
+type EngineQueueStats struct {
+	Depth        uint64  `json:"depth"`
+	LatencyAvgMs float64 `json:"latencyAvgMs"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+
+	// Extra keeps row fields the schema does not describe.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (q *EngineQueueStats) UnmarshalJSON(data []byte) error {
+	type known EngineQueueStats
+	var k known
+	extra, err := unmarshalWithExtra(data, &k)
+	*q = EngineQueueStats(k)
+	q.Extra = extra
+	return err
+}
+
+func (q EngineQueueStats) MarshalJSON() ([]byte, error) {
+	type known EngineQueueStats
+	return jsonObject(known(q), q.Extra)
+}
+
+type EngineWorkerStats struct {
+	Utilization float64 `json:"utilization"`
+	Packets     uint64  `json:"packets,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (w *EngineWorkerStats) UnmarshalJSON(data []byte) error {
+	type known EngineWorkerStats
+	var k known
+	extra, err := unmarshalWithExtra(data, &k)
+	*w = EngineWorkerStats(k)
+	w.Extra = extra
+	return err
+}
+
+func (w EngineWorkerStats) MarshalJSON() ([]byte, error) {
+	type known EngineWorkerStats
+	return jsonObject(known(w), w.Extra)
+}
+
+// EngineStats is what the NFQ engine reports about itself in
+// sys_stats_file. CPU and memory are its own view of the process and may
+// differ from the systemd figures in /api/processes.
+type EngineStats struct {
+	Version int                          `json:"version"`
+	CPU     float64                      `json:"cpu"`
+	Memory  uint64                       `json:"memory"`
+	Uptime  uint64                       `json:"uptime,omitempty"`
+	Queues  map[string]EngineQueueStats  `json:"queues,omitempty"`
+	Workers map[string]EngineWorkerStats `json:"workers,omitempty"`
+
+	// Extra keeps top-level fields the schema does not describe.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// engineStatsVersion is the newest sys_stats_file schema this dashboard
+// understands.
+const engineStatsVersion = 1
+
+// parseEngineStats decodes sys_stats_file the same way as net_stats_file:
+// cpu and memory are required, the rest must match the schema if present
+// and unknown fields pass through.
+func parseEngineStats(content []byte) (*EngineStats, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+
+	stats := &EngineStats{Version: 1}
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &stats.Version); err != nil || stats.Version < 1 {
+			return nil, fmt.Errorf("field %q must be a positive integer", "version")
+		}
+		if stats.Version > engineStatsVersion {
+			return nil, fmt.Errorf("stats version %d is newer than supported version %d", stats.Version, engineStatsVersion)
+		}
+		delete(fields, "version")
+	}
+
+	var cpu, memory *float64
+	for _, field := range []struct {
+		name  string
+		value **float64
+	}{
+		{"cpu", &cpu},
+		{"memory", &memory},
+	} {
+		if err := json.Unmarshal(fields[field.name], field.value); err != nil || *field.value == nil || **field.value < 0 {
+			return nil, missingField(field.name)
+		}
+		delete(fields, field.name)
+	}
+	stats.CPU = *cpu
+	stats.Memory = uint64(*memory)
+
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"uptime", &stats.Uptime},
+		{"queues", &stats.Queues},
+		{"workers", &stats.Workers},
+	} {
+		raw, ok := fields[field.name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, field.value); err != nil {
+			return nil, fmt.Errorf("field %q: %v", field.name, err)
+		}
+		delete(fields, field.name)
+	}
+
+	if len(fields) > 0 {
+		stats.Extra = fields
+	}
+	return stats, nil
+}
+
+func (e EngineStats) MarshalJSON() ([]byte, error) {
+	type known EngineStats
+	return jsonObject(known(e), e.Extra)
+}
+
+func (e *EngineStats) cpuPercent() (float64, bool)  { return e.CPU, true }
+func (e *EngineStats) memoryBytes() (float64, bool) { return float64(e.Memory), true }
+
+func (e *EngineStats) queueDepth() (float64, bool) {
+	var depth uint64
+	for _, q := range e.Queues {
+		depth += q.Depth
+	}
+	return float64(depth), len(e.Queues) > 0
+}
+
+// queueLatencyAvg and queueLatencyP99 report the worst queue.
+func (e *EngineStats) queueLatencyAvg() (float64, bool) {
+	return e.worstQueue(func(q EngineQueueStats) float64 { return q.LatencyAvgMs })
+}
+
+func (e *EngineStats) queueLatencyP99() (float64, bool) {
+	return e.worstQueue(func(q EngineQueueStats) float64 { return q.LatencyP99Ms })
+}
+
+func (e *EngineStats) worstQueue(get func(q EngineQueueStats) float64) (float64, bool) {
+	if len(e.Queues) == 0 {
+		return 0, false
+	}
+	var worst float64
+	for _, q := range e.Queues {
+		worst = math.Max(worst, get(q))
+	}
+	return worst, true
+}
+
+// workerUtilization is the mean over all workers.
+func (e *EngineStats) workerUtilization() (float64, bool) {
+	if len(e.Workers) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, w := range e.Workers {
+		sum += w.Utilization
+	}
+	return sum / float64(len(e.Workers)), true
+}
+
+// readEngineStats returns the engine's own stats and the freshness of
+// sys_stats_file; the stats are nil when the file is unusable.
+func (d *Dashboard) readEngineStats() (*EngineStats, *SourceStatus) {
+	content, source := d.readStatsSource(getConfig().SYS_STATS_FILE)
+	if content == nil {
+		return nil, source
+	}
+
+	stats, err := parseEngineStats(content)
+	if err != nil {
+		source.unavailable(err)
+		return nil, source
+	}
+	return stats, source
+}
+
+type engineStatsResponse struct {
+	*EngineStats
+	*SourceStatus
+}
+
+func (r engineStatsResponse) MarshalJSON() ([]byte, error) {
+	return jsonObject(r.SourceStatus, r.EngineStats)
+}
+
+func (d *Dashboard) engineStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, source := d.readEngineStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	if stats == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(engineStatsResponse{stats, source})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultStatsStaleAfter = 30 * time.Second
+
+const (
+	sourceOK          = "ok"
+	sourceStale       = "stale"
+	sourceUnavailable = "unavailable"
+)
+
+// SourceStatus tells how fresh a stats file written by the NFQ engine is.
+// Values read from a stale file are still returned; an unavailable source
+// has none.
+type SourceStatus struct {
+	Status  string  `json:"status"`
+	File    string  `json:"file"`
+	ModTime int64   `json:"mtime,omitempty"`
+	Age     float64 `json:"age,omitempty"`
+	Stale   bool    `json:"stale"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func statsStaleAfter(cfg *AppConfig) time.Duration {
+	if d, err := time.ParseDuration(cfg.StatsStaleAfter); err == nil && d > 0 {
+		return d
+	}
+	return defaultStatsStaleAfter
+}
+
+// readStatsSource reads a stats file and reports its freshness against
+// the dashboard clock.
+func (d *Dashboard) readStatsSource(file string) ([]byte, *SourceStatus) {
+	status := &SourceStatus{Status: sourceUnavailable, File: file}
+
+	path := d.path(file)
+	info, err := os.Stat(path)
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+	status.ModTime = info.ModTime().Unix()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+
+	age := d.clock.Now().Sub(info.ModTime())
+	if age < 0 {
+		age = 0
+	}
+	status.Age = age.Round(time.Millisecond).Seconds()
+	status.Stale = age > statsStaleAfter(getConfig())
+	status.Status = sourceOK
+	if status.Stale {
+		status.Status = sourceStale
+	}
+	return content, status
+}
+
+// unavailable marks a source whose content could not be used.
+func (s *SourceStatus) unavailable(err error) {
+	s.Status = sourceUnavailable
+	s.Stale = false
+	s.Error = err.Error()
+}
+
+func missingField(name string) error {
+	return fmt.Errorf("field %q is missing or not a number", name)
+}
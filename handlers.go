@@ -2,58 +2,91 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func (d *Dashboard) mainHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/index.html")
+	d.assets.servePage(w, r, "index.html")
 }
 
 func (d *Dashboard) wsStatsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := d.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.Warn("WebSocket upgrade failed", "path", r.URL.Path, "error", err)
 		return
 	}
-	defer conn.Close()
+	d.wg.Add(1)
+	defer d.wg.Done()
+
+	updates := d.statsHub.Subscribe()
+	defer d.statsHub.Unsubscribe(updates)
+	packetUpdates := d.packetHub.Subscribe()
+	defer d.packetHub.Unsubscribe(packetUpdates)
+
+	// A publish between Subscribe and here already queued a newer value.
+	if stats := d.statsHub.Latest(); stats != nil {
+		select {
+		case updates <- stats:
+		default:
+		}
+	}
+	if packets := d.packetHub.Latest(); packets != nil {
+		select {
+		case packetUpdates <- packets:
+		default:
+		}
+	}
 
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
 
-	for range ticker.C {
-		stats, err := d.getSystemStats()
-		if err != nil {
-			log.Printf("Error getting stats: %v", err)
-			continue
+	for {
+		var message interface{}
+		select {
+		case <-d.ctx.Done():
+			closeWebSocket(conn)
+			return
+		case <-closed:
+			conn.Close()
+			return
+		case message = <-updates:
+		case message = <-packetUpdates:
 		}
 
-		if err := conn.WriteJSON(stats); err != nil {
-			log.Printf("WebSocket write error: %v", err)
+		if err := conn.WriteJSON(message); err != nil {
+			slog.Debug("Stats client disconnected", "client", clientIP(r), "error", err)
+			conn.Close()
 			return
 		}
 	}
 }
 
 func (d *Dashboard) configHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/config.html")
+	d.assets.servePage(w, r, "config.html")
 }
 
 func (d *Dashboard) logsHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/logs.html")
+	d.assets.servePage(w, r, "logs.html")
 }
 
 func (d *Dashboard) getLogsHandler(w http.ResponseWriter, r *http.Request) {
-	content, err := os.ReadFile(config.NFQ_LOG_FILE)
+	content, err := os.ReadFile(d.path(getConfig().NFQ_LOG_FILE))
 	if err != nil {
 		http.Error(w, "Не удалось прочитать файл логов", http.StatusInternalServerError)
 		return
@@ -69,7 +102,7 @@ func (d *Dashboard) getLogsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *Dashboard) configAPIHandler(w http.ResponseWriter, r *http.Request) {
-	filename := config.NFQ_CONFIG_FILE
+	filename := d.path(getConfig().NFQ_CONFIG_FILE)
 	switch r.Method {
 	case "GET":
 		content, err := os.ReadFile(filename)
@@ -88,16 +121,37 @@ func (d *Dashboard) configAPIHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if len(content) > 0 {
-			var jsonData interface{}
-			if err := json.Unmarshal(content, &jsonData); err != nil {
-				log.Printf("Warning: Config contains invalid JSON but will be saved: %v", err)
+		force := r.URL.Query().Get("force") == "true"
+
+		schemaFile := nfqSchemaPath(filename)
+		schema, err := loadJSONSchema(schemaFile)
+		if err != nil && !force {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Ошибка в схеме конфигурации: " + err.Error(),
 			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
 		}
 
-		configData := content
+		if errs := validateJSONDocument(content, schema); len(errs) > 0 {
+			if !force {
+				response := map[string]interface{}{
+					"success": false,
+					"error":   "Конфигурация не прошла проверку",
+					"errors":  errs,
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+			slog.Warn("Saving NFQ config that failed validation", "file", filename, "errors", len(errs), "client", clientIP(r))
+		}
 
-		if err := os.WriteFile(filename, configData, 0644); err != nil {
+		if err := os.WriteFile(filename, content, 0644); err != nil {
 			http.Error(w, "Не удалось сохранить конфигурацию", http.StatusInternalServerError)
 			return
 		}
@@ -112,8 +166,100 @@ func (d *Dashboard) configAPIHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (d *Dashboard) settingsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		settings, _, err := readConfig(d.configPath())
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Не удалось прочитать настройки: " + err.Error(),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		effective := getConfig()
+		response := map[string]interface{}{
+			"success":         true,
+			"settings":        settings,
+			"effective":       effective,
+			"overrides":       overriddenKeys(d.reloader.overrides),
+			"restartRequired": restartRequiredKeys(d.reloader.started, effective),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case "PUT":
+		var settings AppConfig
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&settings); err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Некорректные данные: " + err.Error(),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		if settings.Version == 0 {
+			settings.Version = configVersion
+		}
+
+		var problems []string
+		if settings.Version != configVersion {
+			problems = append(problems, fmt.Sprintf("version: expected %d, got %d", configVersion, settings.Version))
+		}
+		var cfgErr *configError
+		if err := d.configEnv().validate(&settings); errors.As(err, &cfgErr) {
+			problems = append(problems, cfgErr.problems...)
+		}
+		if len(problems) > 0 {
+			response := map[string]interface{}{
+				"success":  false,
+				"error":    "Некорректные настройки",
+				"problems": problems,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		effective, err := d.reloader.save(&settings)
+		if err != nil {
+			response := map[string]interface{}{
+				"success": false,
+				"error":   "Ошибка сохранения настроек: " + err.Error(),
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		response := map[string]interface{}{
+			"success":         true,
+			"message":         "Настройки сохранены",
+			"settings":        settings,
+			"effective":       effective,
+			"overrides":       overriddenKeys(d.reloader.overrides),
+			"restartRequired": restartRequiredKeys(d.reloader.started, effective),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
 func (d *Dashboard) rulesHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./static/rules.html")
+	d.assets.servePage(w, r, "rules.html")
 }
 
 func (d *Dashboard) rulesAPIHandler(w http.ResponseWriter, r *http.Request) {
@@ -142,7 +288,7 @@ func (d *Dashboard) rulesAPIHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if rule.ID == "" {
-			rule.ID = fmt.Sprintf("rule_%d", time.Now().Unix())
+			rule.ID = fmt.Sprintf("rule_%d", d.clock.Now().Unix())
 		}
 
 		if err := d.saveRule(&rule); err != nil {
@@ -247,7 +393,7 @@ func (d *Dashboard) ruleAPIHandler(w http.ResponseWriter, r *http.Request) {
 func (d *Dashboard) loadAllRules() ([]Rule, error) {
 	var rules []Rule
 
-	files, err := os.ReadDir(config.NFQ_RULES_DIR)
+	files, err := os.ReadDir(d.path(getConfig().NFQ_RULES_DIR))
 	if err != nil {
 		return rules, err
 	}
@@ -270,7 +416,7 @@ func (d *Dashboard) loadAllRules() ([]Rule, error) {
 }
 
 func (d *Dashboard) loadRule(ruleID string) (*Rule, error) {
-	filePath := filepath.Join(config.NFQ_RULES_DIR, ruleID+".json")
+	filePath := d.rulePath(ruleID)
 
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -286,11 +432,11 @@ func (d *Dashboard) loadRule(ruleID string) (*Rule, error) {
 }
 
 func (d *Dashboard) saveRule(rule *Rule) error {
-	if err := os.MkdirAll(config.NFQ_RULES_DIR, 0755); err != nil {
+	if err := os.MkdirAll(d.path(getConfig().NFQ_RULES_DIR), 0755); err != nil {
 		return err
 	}
 
-	filePath := filepath.Join(config.NFQ_RULES_DIR, rule.ID+".json")
+	filePath := d.rulePath(rule.ID)
 
 	ruleData, err := json.MarshalIndent(rule, "", "    ")
 	if err != nil {
@@ -301,12 +447,11 @@ func (d *Dashboard) saveRule(rule *Rule) error {
 }
 
 func (d *Dashboard) deleteRule(ruleID string) error {
-	filePath := filepath.Join(config.NFQ_RULES_DIR, ruleID+".json")
-	return os.Remove(filePath)
+	return os.Remove(d.rulePath(ruleID))
 }
 
 func (d *Dashboard) ruleFilesHandler(w http.ResponseWriter, r *http.Request) {
-	files, err := os.ReadDir(config.NFQ_RULES_DIR)
+	files, err := os.ReadDir(d.path(getConfig().NFQ_RULES_DIR))
 	if err != nil {
 		response := map[string]interface{}{
 			"success": false,
@@ -352,7 +497,7 @@ func (d *Dashboard) rawRuleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := filepath.Join(config.NFQ_RULES_DIR, filename+".json")
+	filePath := d.rulePath(filename)
 
 	switch r.Method {
 	case "GET":
@@ -372,7 +517,7 @@ func (d *Dashboard) rawRuleHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write(content)
 
 	case "POST", "PUT":
-		if err := os.MkdirAll(config.NFQ_RULES_DIR, 0755); err != nil {
+		if err := os.MkdirAll(d.path(getConfig().NFQ_RULES_DIR), 0755); err != nil {
 			response := map[string]interface{}{
 				"success": false,
 				"error":   "Ошибка создания директории: " + err.Error(),
@@ -398,7 +543,7 @@ func (d *Dashboard) rawRuleHandler(w http.ResponseWriter, r *http.Request) {
 		if len(content) > 0 {
 			var jsonData interface{}
 			if err := json.Unmarshal(content, &jsonData); err != nil {
-				log.Printf("Warning: File %s contains invalid JSON but will be saved: %v", filename, err)
+				slog.Warn("Rule file contains invalid JSON but will be saved", "file", filePath, "error", err)
 			}
 		}
 
@@ -0,0 +1,1329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// fakeClock is also read by the background watchers, hence the lock.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.set(c.Now().Add(d))
+}
+
+type fakeStats struct {
+	mu       sync.Mutex
+	cpu      float64
+	memory   mem.VirtualMemoryStat
+	disk     disk.UsageStat
+	usage    map[string]disk.UsageStat
+	diskIO   map[string]disk.IOCountersStat
+	counters []net.IOCountersStat
+	ifaces   net.InterfaceStatList
+	temps    []host.TemperatureStat
+	cores    []float64
+	load     load.AvgStat
+	uptime   uint64
+	procs    map[int32]ProcessSample
+}
+
+func (s *fakeStats) CPUPercent(time.Duration) (float64, error) {
+	return s.cpu, nil
+}
+
+func (s *fakeStats) VirtualMemory() (*mem.VirtualMemoryStat, error) {
+	return &s.memory, nil
+}
+
+func (s *fakeStats) DiskUsage(path string) (*disk.UsageStat, error) {
+	if usage, ok := s.usage[path]; ok {
+		return &usage, nil
+	}
+	if strings.HasSuffix(path, "/missing") {
+		return nil, os.ErrNotExist
+	}
+	return &s.disk, nil
+}
+
+func (s *fakeStats) DiskIOCounters() (map[string]disk.IOCountersStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.diskIO, nil
+}
+
+func (s *fakeStats) setDiskIO(counters map[string]disk.IOCountersStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.diskIO = counters
+}
+
+func (s *fakeStats) NetIOCounters() ([]net.IOCountersStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]net.IOCountersStat(nil), s.counters...), nil
+}
+
+func (s *fakeStats) Interfaces() (net.InterfaceStatList, error) {
+	return s.ifaces, nil
+}
+
+func (s *fakeStats) Temperatures() ([]host.TemperatureStat, error) {
+	return s.temps, nil
+}
+
+func (s *fakeStats) PerCoreCPUPercent() ([]float64, error) {
+	return s.cores, nil
+}
+
+func (s *fakeStats) LoadAvg() (*load.AvgStat, error) {
+	return &s.load, nil
+}
+
+func (s *fakeStats) Uptime() (uint64, error) {
+	return s.uptime, nil
+}
+
+func (s *fakeStats) Process(pid int32) (*ProcessSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.procs[pid]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &p, nil
+}
+
+func (s *fakeStats) setProcesses(procs map[int32]ProcessSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.procs = procs
+}
+
+func (s *fakeStats) setCounters(counters ...net.IOCountersStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = counters
+}
+
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   [][]string
+	err     error
+	output  string
+	outputs int
+}
+
+func (r *fakeRunner) Run(ctx context.Context, name string, args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return r.err
+}
+
+func (r *fakeRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outputs++
+	return []byte(r.output), nil
+}
+
+type testDashboard struct {
+	*Dashboard
+	root   string
+	clock  *fakeClock
+	stats  *fakeStats
+	runner *fakeRunner
+	server *httptest.Server
+	close  func()
+}
+
+func newTestDashboard(t *testing.T) *testDashboard {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, dir := range []string{"nfq/rules", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := setConfig(&AppConfig{
+		Version:         configVersion,
+		NFQ_LOG_FILE:    "/nfq/log.txt",
+		NFQ_CONFIG_FILE: "/nfq/config.json",
+		NFQ_RULES_DIR:   "/nfq/rules",
+		NET_STATS_FILE:  "/tmp/nfq.json",
+		SYS_STATS_FILE:  "/tmp/sys.json",
+		LogLevel:        "info",
+		Interface:       "wan0",
+		ListenPort:      "8080",
+		MetricsDir:      "/metrics",
+	})
+	t.Cleanup(func() { setConfig(old) })
+
+	td := &testDashboard{
+		root:   root,
+		clock:  &fakeClock{now: time.Unix(1700000000, 0)},
+		stats:  &fakeStats{},
+		runner: &fakeRunner{},
+	}
+	td.stats.setCounters(net.IOCountersStat{Name: "wan0", BytesRecv: 1000, BytesSent: 500})
+	td.open(t)
+	t.Cleanup(func() { td.close() })
+	return td
+}
+
+// open starts a dashboard on td.root; reopen simulates a restart.
+func (td *testDashboard) open(t *testing.T) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d, err := NewDashboard(ctx, DashboardOptions{
+		Root:     td.root,
+		Clock:    td.clock,
+		Stats:    td.stats,
+		Commands: td.runner,
+		// Tests call sampleStats directly.
+		StatsInterval: time.Hour,
+	})
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	td.Dashboard = d
+	td.server = httptest.NewServer(d.router())
+	td.close = func() {
+		td.server.Close()
+		cancel()
+		d.shutdown()
+	}
+}
+
+func (td *testDashboard) reopen(t *testing.T) {
+	t.Helper()
+	td.close()
+	td.open(t)
+}
+
+func (td *testDashboard) writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(td.root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (td *testDashboard) do(t *testing.T, method, path, body string) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, td.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func decodeJSON(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", data, err)
+	}
+}
+
+func TestPagesServedFromEmbeddedAssets(t *testing.T) {
+	td := newTestDashboard(t)
+
+	for _, page := range []string{"/", "/config", "/logs", "/rules", "/static/app.js"} {
+		status, body := td.do(t, "GET", page, "")
+		if status != http.StatusOK || len(body) == 0 {
+			t.Errorf("GET %s: status %d, %d bytes", page, status, len(body))
+		}
+	}
+}
+
+func TestStatsUseProviderAndClock(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.cpu = 42.5
+	td.stats.memory = mem.VirtualMemoryStat{Total: 1024, Used: 512, UsedPercent: 50}
+	td.stats.disk = disk.UsageStat{Total: 2048, Used: 1024, UsedPercent: 50}
+	td.stats.setCounters(net.IOCountersStat{Name: "wan0", BytesRecv: 4000, BytesSent: 1500, PacketsRecv: 20, Errin: 2})
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+
+	status, body := td.do(t, "GET", "/api/stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+
+	var stats SystemStats
+	decodeJSON(t, body, &stats)
+	for _, source := range []*SourceStatus{stats.PacketSource, stats.EngineSource} {
+		if source == nil || source.Status != sourceUnavailable {
+			t.Errorf("source = %+v, want unavailable", source)
+		}
+	}
+	stats.PacketSource, stats.EngineSource = nil, nil
+	want := SystemStats{
+		CPU: 42.5, RAM: 50, RAMUsed: 512, RAMTotal: 1024,
+		Disk: 50, DiskUsed: 1024, DiskTotal: 2048,
+		Mounts:    []MountStats{{Path: "/", Total: 2048, Used: 1024, UsedPercent: 50}},
+		DiskIO:    map[string]DiskIOStats{},
+		Interface: "wan0",
+		Interfaces: map[string]SpeedStats{
+			"wan0": {
+				Download: 1500, Upload: 500, PacketsIn: 10,
+				ErrorsInRate: 1, ErrorsIn: 2, Interval: 2,
+			},
+		},
+		Processes: []ProcessStats{
+			{Service: "web", Unit: "gex-web", State: "unknown", FDs: -1, Error: "unit not reported by systemctl"},
+			{Service: "nfq", Unit: "ips", State: "unknown", FDs: -1, Error: "unit not reported by systemctl"},
+		},
+		Timestamp: 1700000002,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+}
+
+func TestHardwareHealth(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.temps = []host.TemperatureStat{
+		{SensorKey: "gpu_thermal", Temperature: 61},
+		{SensorKey: "cpu_thermal", Temperature: 82, High: 80, Critical: 95},
+	}
+	td.stats.cores = []float64{25, 75}
+	td.stats.load = load.AvgStat{Load1: 1.5, Load5: 1, Load15: 0.5}
+	td.stats.uptime = 3600
+	td.writeFile(t, "sys/devices/system/cpu/cpu0/cpufreq/scaling_cur_freq", "1008000\n")
+	td.writeFile(t, "sys/devices/system/cpu/cpu0/cpufreq/scaling_max_freq", "1008000\n")
+	td.writeFile(t, "sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq", "1512000\n")
+	td.writeFile(t, "sys/devices/system/cpu/cpu1/cpufreq/scaling_cur_freq", "816000\n")
+	// A running fan is not throttling.
+	td.writeFile(t, "sys/class/thermal/cooling_device0/type", "pwm-fan\n")
+	td.writeFile(t, "sys/class/thermal/cooling_device0/cur_state", "3\n")
+	td.writeFile(t, "sys/class/thermal/cooling_device1/type", "cpufreq-cpu0\n")
+	td.writeFile(t, "sys/class/thermal/cooling_device1/cur_state", "0\n")
+	td.clock.advance(10 * time.Second)
+	td.sampleStats()
+
+	_, body := td.do(t, "GET", "/api/stats", "")
+	var stats SystemStats
+	decodeJSON(t, body, &stats)
+
+	if len(stats.Temperatures) != 2 || stats.Temperatures[0].Sensor != "cpu_thermal" {
+		t.Errorf("temperatures = %+v", stats.Temperatures)
+	}
+	wantCores := []CoreStats{
+		{Core: 0, Usage: 25, Freq: 1008, MaxFreq: 1008, HardwareMaxFreq: 1512},
+		{Core: 1, Usage: 75, Freq: 816},
+	}
+	if !reflect.DeepEqual(stats.Cores, wantCores) {
+		t.Errorf("cores = %+v", stats.Cores)
+	}
+	if stats.Load != (LoadStats{Load1: 1.5, Load5: 1, Load15: 0.5}) || stats.Uptime != 3600 {
+		t.Errorf("load = %+v, uptime = %d", stats.Load, stats.Uptime)
+	}
+	if !stats.Throttling.Throttled || !reflect.DeepEqual(stats.Throttling.Reasons, []string{"temperature", "frequency_cap"}) {
+		t.Errorf("throttling = %+v", stats.Throttling)
+	}
+
+	var history struct {
+		Points []historyPoint `json:"points"`
+	}
+	_, body = td.do(t, "GET", "/api/stats/history?metric=temp_max&range=1m&step=1m", "")
+	decodeJSON(t, body, &history)
+	if len(history.Points) != 1 || history.Points[0].Max != 82 || history.Points[0].Count != 1 {
+		t.Errorf("temp_max history = %+v", history.Points)
+	}
+	_, body = td.do(t, "GET", "/api/stats/history?metric=cpu_freq&range=1m&step=1m", "")
+	decodeJSON(t, body, &history)
+	if len(history.Points) != 1 || history.Points[0].Avg != 912 {
+		t.Errorf("cpu_freq history = %+v", history.Points)
+	}
+
+	td.writeFile(t, "sys/class/thermal/cooling_device1/cur_state", "2\n")
+	td.clock.advance(10 * time.Second)
+	td.sampleStats()
+	_, body = td.do(t, "GET", "/api/stats", "")
+	decodeJSON(t, body, &stats)
+	if !reflect.DeepEqual(stats.Throttling.Reasons, []string{"temperature", "cooling", "frequency_cap"}) {
+		t.Errorf("throttling with cpufreq cooling = %+v", stats.Throttling)
+	}
+}
+
+func TestMountsAndDiskIO(t *testing.T) {
+	td := newTestDashboard(t)
+	cfg := *getConfig()
+	cfg.Mounts = []string{"/", "/var/log", "/missing"}
+	cfg.DiskWarnPercent = 80
+	setConfig(&cfg)
+
+	td.stats.usage = map[string]disk.UsageStat{
+		filepath.Join(td.root, "/var/log"): {Path: "/var/log", Fstype: "ext4", Total: 1000, Used: 500, Free: 500,
+			UsedPercent: 50, InodesTotal: 100, InodesUsed: 85, InodesUsedPercent: 85},
+	}
+	td.stats.setDiskIO(map[string]disk.IOCountersStat{
+		"mmcblk0":   {ReadBytes: 1000, WriteBytes: 2000, ReadCount: 10, WriteCount: 20, IoTime: 100},
+		"mmcblk0p1": {ReadBytes: 1000, WriteBytes: 2000, ReadCount: 10, WriteCount: 20, IoTime: 100},
+		"loop0":     {ReadBytes: 5},
+	})
+	td.writeFile(t, "sys/class/block/mmcblk0p1/partition", "1\n")
+	td.sampleStats()
+	td.stats.setDiskIO(map[string]disk.IOCountersStat{
+		"mmcblk0":   {ReadBytes: 5000, WriteBytes: 2000, ReadCount: 18, WriteCount: 24, IoTime: 600},
+		"mmcblk0p1": {ReadBytes: 5000, WriteBytes: 2000, ReadCount: 18, WriteCount: 24, IoTime: 600},
+		"loop0":     {ReadBytes: 50},
+	})
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+
+	_, body := td.do(t, "GET", "/api/stats", "")
+	var stats SystemStats
+	decodeJSON(t, body, &stats)
+
+	if len(stats.Mounts) != 3 {
+		t.Fatalf("mounts = %+v", stats.Mounts)
+	}
+	if m := stats.Mounts[1]; m.Path != "/var/log" || m.Fstype != "ext4" || m.InodesUsed != 85 || !m.Warning {
+		t.Errorf("/var/log = %+v", m)
+	}
+	if m := stats.Mounts[2]; m.Error == "" || !m.Warning {
+		t.Errorf("/missing = %+v", m)
+	}
+	want := map[string]DiskIOStats{
+		"mmcblk0": {ReadBytes: 2000, ReadIOPS: 4, WriteIOPS: 2, Busy: 25, Interval: 2},
+	}
+	if !reflect.DeepEqual(stats.DiskIO, want) {
+		t.Errorf("disk I/O = %+v", stats.DiskIO)
+	}
+
+	// The partition's I/O is not counted twice.
+	_, body = td.do(t, "GET", "/api/stats/history?metric=disk_read&range=1m&step=1m", "")
+	var history struct {
+		Points []historyPoint `json:"points"`
+	}
+	decodeJSON(t, body, &history)
+	if n := len(history.Points); n == 0 || history.Points[n-1].Max != 2000 {
+		t.Errorf("disk_read history = %+v", history.Points)
+	}
+}
+
+func TestProcessesFollowSystemdUnits(t *testing.T) {
+	td := newTestDashboard(t)
+	started := time.Unix(1699990000, 0)
+	td.runner.output = "Id=gex-web.service\nActiveState=active\nMainPID=100\nNRestarts=0\n\n" +
+		"Id=ips.service\nMainPID=200\nActiveState=active\nNRestarts=3\n"
+	td.stats.setProcesses(map[int32]ProcessSample{
+		100: {CPUTime: 10, RSS: 20 << 20, FDs: 12, Threads: 4, CreateTime: started},
+		200: {CPUTime: 50, RSS: 64 << 20, FDs: -1, Threads: 9, CreateTime: started},
+	})
+	// The startup sample cached an empty answer from systemd.
+	td.clock.advance(unitsRefreshInterval)
+	td.sampleStats()
+	if td.runner.outputs != 2 {
+		t.Errorf("systemctl show ran %d times, want 2", td.runner.outputs)
+	}
+
+	// ips restarts with a new PID and grows; gex-web burns 1 s of CPU in 2 s.
+	// The cached PID 200 is gone, so systemd is asked before the interval.
+	td.runner.output = strings.Replace(td.runner.output, "MainPID=200", "MainPID=201", 1)
+	td.stats.setProcesses(map[int32]ProcessSample{
+		100: {CPUTime: 11, RSS: 20 << 20, FDs: 12, Threads: 4, CreateTime: started},
+		201: {CPUTime: 1, RSS: 80 << 20, FDs: -1, Threads: 9, CreateTime: started.Add(time.Hour)},
+	})
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+
+	status, body := td.do(t, "GET", "/api/processes", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var processes []ProcessStats
+	decodeJSON(t, body, &processes)
+	want := []ProcessStats{
+		{Service: "web", Unit: "gex-web", State: "active", PID: 100, CPU: 50, RSS: 20 << 20, FDs: 12, Threads: 4,
+			StartTime: 1699990000},
+		{Service: "nfq", Unit: "ips", State: "active", PID: 201, RSS: 80 << 20, FDs: -1, Threads: 9,
+			StartTime: 1699993600, Restarts: 3, PIDChanges: 1},
+	}
+	if !reflect.DeepEqual(processes, want) {
+		t.Errorf("got %+v, want %+v", processes, want)
+	}
+	if td.runner.outputs != 3 {
+		t.Errorf("systemctl show ran %d times, want 3", td.runner.outputs)
+	}
+
+	var history struct {
+		Points []historyPoint `json:"points"`
+	}
+	_, body = td.do(t, "GET", "/api/stats/history?metric=nfq_rss&range=1m&step=1m", "")
+	decodeJSON(t, body, &history)
+	if len(history.Points) != 1 || history.Points[0].Count != 2 || history.Points[0].Max != 80<<20 {
+		t.Errorf("nfq_rss history = %+v", history.Points)
+	}
+
+	// While the PIDs stay, only /proc is read.
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+	if td.runner.outputs != 3 {
+		t.Errorf("systemctl show ran %d times between refreshes, want 3", td.runner.outputs)
+	}
+}
+
+func TestInterfacesListsEveryNIC(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.ifaces = net.InterfaceStatList{
+		{Index: 2, MTU: 1500, Name: "wan0", HardwareAddr: "aa:bb:cc:dd:ee:01", Flags: []string{"up", "broadcast"},
+			Addrs: net.InterfaceAddrList{{Addr: "192.0.2.10/24"}}},
+		{Index: 3, MTU: 1500, Name: "lan0", Flags: []string{"broadcast"}},
+	}
+	td.writeFile(t, "sys/class/net/wan0/operstate", "up\n")
+	td.writeFile(t, "sys/class/net/wan0/speed", "1000\n")
+	td.writeFile(t, "sys/class/net/lan0/speed", "-1\n")
+
+	td.stats.setCounters(
+		net.IOCountersStat{Name: "wan0", BytesRecv: 3000, BytesSent: 500},
+		net.IOCountersStat{Name: "lan0", BytesRecv: 800},
+	)
+	td.clock.advance(time.Second)
+	td.sampleStats()
+
+	status, body := td.do(t, "GET", "/api/interfaces", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var interfaces []InterfaceInfo
+	decodeJSON(t, body, &interfaces)
+	if len(interfaces) != 2 || interfaces[0].Name != "lan0" || interfaces[1].Name != "wan0" {
+		t.Fatalf("got %+v", interfaces)
+	}
+
+	lan, wan := interfaces[0], interfaces[1]
+	if lan.Up || lan.Primary || lan.SpeedMbps != 0 || lan.Rates == nil || lan.Rates.Download != 0 {
+		t.Errorf("lan0 = %+v", lan)
+	}
+	if !wan.Up || !wan.Primary || wan.OperState != "up" || wan.SpeedMbps != 1000 ||
+		!reflect.DeepEqual(wan.Addresses, []string{"192.0.2.10/24"}) || wan.Rates == nil || wan.Rates.Download != 2000 {
+		t.Errorf("wan0 = %+v, rates %+v", wan, wan.Rates)
+	}
+}
+
+func TestPacketStatsReadsStatsFile(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "tmp/nfq.json", `{"total": 30, "passed": 20, "blocked": 10}`)
+
+	_, body := td.do(t, "GET", "/api/packet-stats", "")
+	var stats PacketStats
+	decodeJSON(t, body, &stats)
+	if !reflect.DeepEqual(stats, PacketStats{Version: 1, Total: 30, Passed: 20, Blocked: 10}) {
+		t.Errorf("got %+v", stats)
+	}
+
+	td.sampleStats()
+	td.writeFile(t, "tmp/nfq.json", `{"total": 70, "passed": 50, "blocked": 20}`)
+	td.clock.advance(10 * time.Second)
+	td.sampleStats()
+
+	_, body = td.do(t, "GET", "/api/packet-stats", "")
+	var response packetStatsResponse
+	decodeJSON(t, body, &response)
+	want := PacketRates{Total: 4, Passed: 3, Blocked: 1, BlockRatio: 0.25, Window: 10}
+	if response.Rates == nil || response.Rates.Current != want || response.Rates.Windows["5m"] != want {
+		t.Errorf("rates = %+v", response.Rates)
+	}
+}
+
+func TestPacketStatsFreshness(t *testing.T) {
+	td := newTestDashboard(t)
+
+	status, body := td.do(t, "GET", "/api/packet-stats", "")
+	var response packetStatsResponse
+	decodeJSON(t, body, &response)
+	if status != http.StatusServiceUnavailable || response.PacketStats != nil ||
+		response.SourceStatus == nil || response.Status != sourceUnavailable || response.Error == "" {
+		t.Errorf("missing file: status %d, body %s", status, body)
+	}
+
+	td.writeFile(t, "tmp/nfq.json", `{"total": 30, "passed": 20}`)
+	if status, body = td.do(t, "GET", "/api/packet-stats", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("missing field: status %d, body %s", status, body)
+	}
+
+	td.writeFile(t, "tmp/nfq.json", `{"total": 30, "passed": 20, "blocked": 10}`)
+	old := td.clock.Now().Add(-time.Minute)
+	if err := os.Chtimes(filepath.Join(td.root, "tmp/nfq.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	status, body = td.do(t, "GET", "/api/packet-stats", "")
+	response = packetStatsResponse{}
+	decodeJSON(t, body, &response)
+	if status != http.StatusOK || response.PacketStats == nil || response.Total != 30 ||
+		response.Status != sourceStale || !response.Stale || response.Age != 60 {
+		t.Errorf("stale file: status %d, body %s", status, body)
+	}
+
+	td.clock.set(old.Add(5 * time.Second))
+	_, body = td.do(t, "GET", "/api/packet-stats", "")
+	response = packetStatsResponse{}
+	decodeJSON(t, body, &response)
+	if response.Status != sourceOK || response.Stale {
+		t.Errorf("fresh file: %s", body)
+	}
+}
+
+func TestPacketStatsSchema(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "tmp/nfq.json", `{
+		"version": 1, "total": 30, "passed": 20, "blocked": 10,
+		"bytes": {"total": 3000, "passed": 2000, "blocked": 1000},
+		"protocols": {"tcp": {"packets": 25, "bytes": 2500, "passed": 16, "blocked": 9, "flows": 4}},
+		"queues": {"0": {"packets": 30, "bytes": 3000, "passed": 20, "blocked": 10, "dropped": 2}},
+		"rules": {"rule_1": {"hits": 9, "bytes": 900, "lastHit": 1699999990, "action": "drop"}},
+		"conntrack": {"entries": 120}, "status": "shadowed"
+	}`)
+
+	status, body := td.do(t, "GET", "/api/packet-stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var response packetStatsResponse
+	decodeJSON(t, body, &response)
+	if response.Bytes == nil || *response.Bytes != (ByteCounters{Total: 3000, Passed: 2000, Blocked: 1000}) ||
+		response.Protocols["tcp"].Blocked != 9 || response.Queues["0"].Dropped != 2 || response.Rules["rule_1"].Hits != 9 {
+		t.Errorf("breakdowns not decoded: %s", body)
+	}
+	if response.Status != sourceOK {
+		t.Errorf("engine field shadowed the source status: %s", body)
+	}
+
+	var raw map[string]json.RawMessage
+	decodeJSON(t, body, &raw)
+	if string(raw["conntrack"]) != `{"entries":120}` {
+		t.Errorf("unknown field not passed through: %s", body)
+	}
+	var rows struct {
+		Protocols map[string]map[string]json.RawMessage `json:"protocols"`
+		Rules     map[string]map[string]json.RawMessage `json:"rules"`
+	}
+	decodeJSON(t, body, &rows)
+	if string(rows.Protocols["tcp"]["flows"]) != "4" || string(rows.Protocols["tcp"]["blocked"]) != "9" ||
+		string(rows.Rules["rule_1"]["action"]) != `"drop"` {
+		t.Errorf("unknown row fields not passed through: %s", body)
+	}
+
+	td.writeFile(t, "tmp/nfq.json", `{"total": 30, "passed": 20, "blocked": 10, "rules": []}`)
+	if status, body = td.do(t, "GET", "/api/packet-stats", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("malformed breakdown: status %d, body %s", status, body)
+	}
+
+	td.writeFile(t, "tmp/nfq.json", `{"version": 2, "total": 30, "passed": 20, "blocked": 10}`)
+	status, body = td.do(t, "GET", "/api/packet-stats", "")
+	if status != http.StatusServiceUnavailable || !strings.Contains(string(body), "newer than supported") {
+		t.Errorf("newer version: status %d, body %s", status, body)
+	}
+}
+
+func TestEngineStats(t *testing.T) {
+	td := newTestDashboard(t)
+
+	if status, body := td.do(t, "GET", "/api/engine-stats", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("missing file: status %d, body %s", status, body)
+	}
+
+	td.writeFile(t, "tmp/sys.json", `{
+		"version": 1, "cpu": 35.5, "memory": 52428800, "uptime": 600,
+		"queues": {"0": {"depth": 4, "latencyAvgMs": 0.5, "latencyP99Ms": 3}, "1": {"depth": 1, "latencyAvgMs": 1.5, "latencyP99Ms": 2}},
+		"workers": {"0": {"utilization": 40, "cpu": 2}, "1": {"utilization": 80}},
+		"gcPauses": 7
+	}`)
+	status, body := td.do(t, "GET", "/api/engine-stats", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var raw map[string]json.RawMessage
+	decodeJSON(t, body, &raw)
+	if string(raw["cpu"]) != "35.5" || string(raw["gcPauses"]) != "7" || string(raw["status"]) != `"ok"` {
+		t.Errorf("got %s", body)
+	}
+	var workers map[string]map[string]json.RawMessage
+	decodeJSON(t, raw["workers"], &workers)
+	if string(workers["0"]["cpu"]) != "2" || string(workers["1"]["utilization"]) != "80" {
+		t.Errorf("unknown worker fields not passed through: %s", raw["workers"])
+	}
+
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+	_, body = td.do(t, "GET", "/api/stats", "")
+	var stats SystemStats
+	decodeJSON(t, body, &stats)
+	if stats.Engine == nil || stats.Engine.Memory != 52428800 || stats.EngineSource.Status != sourceOK {
+		t.Fatalf("engine in snapshot = %+v, source %+v", stats.Engine, stats.EngineSource)
+	}
+
+	for metric, want := range map[string]float64{
+		"engine_cpu":         35.5,
+		"queue_depth":        5,
+		"queue_latency":      1.5,
+		"queue_latency_p99":  3,
+		"worker_utilization": 60,
+	} {
+		_, body = td.do(t, "GET", "/api/stats/history?metric="+metric+"&range=1m&step=10s", "")
+		var response struct {
+			Points []historyPoint `json:"points"`
+		}
+		decodeJSON(t, body, &response)
+		if n := len(response.Points); n == 0 || response.Points[n-1].Avg != want {
+			t.Errorf("%s history = %+v, want last %v", metric, response.Points, want)
+		}
+	}
+
+	td.writeFile(t, "tmp/sys.json", `{"cpu": 35.5}`)
+	if status, body = td.do(t, "GET", "/api/engine-stats", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("missing memory: status %d, body %s", status, body)
+	}
+
+	td.writeFile(t, "tmp/sys.json", `{"version": 2, "cpu": 35.5, "memory": 1}`)
+	if status, body = td.do(t, "GET", "/api/engine-stats", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("newer version: status %d, body %s", status, body)
+	}
+}
+
+func TestRulesCRUD(t *testing.T) {
+	td := newTestDashboard(t)
+
+	status, body := td.do(t, "POST", "/api/rules", `{"name": "ssh", "action": "drop", "destPort": 22}`)
+	if status != http.StatusOK {
+		t.Fatalf("create: status %d: %s", status, body)
+	}
+	if _, err := os.Stat(filepath.Join(td.root, "nfq/rules/rule_1700000000.json")); err != nil {
+		t.Fatalf("rule file not created under root: %v", err)
+	}
+
+	status, body = td.do(t, "PUT", "/api/rules/rule_1700000000", `{"name": "ssh", "action": "accept", "destPort": 22}`)
+	if status != http.StatusOK {
+		t.Fatalf("update: status %d: %s", status, body)
+	}
+
+	_, body = td.do(t, "GET", "/api/rules", "")
+	var rules []Rule
+	decodeJSON(t, body, &rules)
+	if len(rules) != 1 || rules[0].ID != "rule_1700000000" || rules[0].Action != "accept" {
+		t.Fatalf("got %+v", rules)
+	}
+
+	if status, body = td.do(t, "DELETE", "/api/rules/rule_1700000000", ""); status != http.StatusOK {
+		t.Fatalf("delete: status %d: %s", status, body)
+	}
+	if status, _ = td.do(t, "GET", "/api/rules/rule_1700000000", ""); status != http.StatusNotFound {
+		t.Errorf("deleted rule: status %d, want 404", status)
+	}
+}
+
+func TestRawRuleFiles(t *testing.T) {
+	td := newTestDashboard(t)
+
+	if status, body := td.do(t, "PUT", "/api/rules/raw/custom", `{"any": "content"}`); status != http.StatusOK {
+		t.Fatalf("save: status %d: %s", status, body)
+	}
+
+	_, body := td.do(t, "GET", "/api/rules/files", "")
+	var files struct {
+		Files []string `json:"files"`
+	}
+	decodeJSON(t, body, &files)
+	if !reflect.DeepEqual(files.Files, []string{"custom"}) {
+		t.Errorf("files = %v", files.Files)
+	}
+
+	if status, _ := td.do(t, "GET", "/api/rules/raw/a..b", ""); status != http.StatusBadRequest {
+		t.Errorf("traversal: status %d, want 400", status)
+	}
+}
+
+func TestConfigAPIRoundTrip(t *testing.T) {
+	td := newTestDashboard(t)
+
+	content := `{"queue": 1}`
+	if status, body := td.do(t, "POST", "/api/config", content); status != http.StatusOK {
+		t.Fatalf("save: status %d: %s", status, body)
+	}
+	if _, body := td.do(t, "GET", "/api/config", ""); string(body) != content {
+		t.Errorf("got %q, want %q", body, content)
+	}
+}
+
+func TestSettingsAPIRoundTrip(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.ifaces = net.InterfaceStatList{{Name: "wan0"}}
+	t.Setenv("GEX_LOG_LEVEL", "debug")
+	path := filepath.Join(td.root, configFile)
+
+	type settingsResponse struct {
+		Settings        AppConfig         `json:"settings"`
+		Effective       AppConfig         `json:"effective"`
+		Overrides       map[string]string `json:"overrides"`
+		RestartRequired []string          `json:"restartRequired"`
+	}
+
+	status, body := td.do(t, "GET", "/api/settings", "")
+	if status != http.StatusOK {
+		t.Fatalf("get: status %d: %s", status, body)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("GET wrote %s: %v", path, err)
+	}
+
+	var got settingsResponse
+	decodeJSON(t, body, &got)
+	settings := got.Effective
+	settings.ListenPort = "9090"
+	data, _ := json.Marshal(settings)
+
+	status, body = td.do(t, "PUT", "/api/settings", string(data))
+	if status != http.StatusOK {
+		t.Fatalf("put: status %d: %s", status, body)
+	}
+	var saved settingsResponse
+	decodeJSON(t, body, &saved)
+	if !reflect.DeepEqual(saved.RestartRequired, []string{"listenPort"}) {
+		t.Errorf("restartRequired = %v", saved.RestartRequired)
+	}
+	if saved.Overrides["logLevel"] != "GEX_LOG_LEVEL" || saved.Effective.LogLevel != "debug" {
+		t.Errorf("overrides = %v, effective logLevel %q", saved.Overrides, saved.Effective.LogLevel)
+	}
+
+	_, body = td.do(t, "GET", "/api/settings", "")
+	decodeJSON(t, body, &got)
+	if got.Settings.ListenPort != "9090" || got.Settings.LogLevel == "debug" {
+		t.Errorf("settings after save = %+v", got.Settings)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("PUT did not write %s: %v", path, err)
+	}
+
+	if status, _ := td.do(t, "PUT", "/api/settings", `{"interface": "eth9"}`); status != http.StatusBadRequest {
+		t.Errorf("unknown interface: status %d, want 400", status)
+	}
+}
+
+func TestLogsAPIAndWebSocket(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "nfq/log.txt", "first\nsecond\n")
+
+	if _, body := td.do(t, "GET", "/api/logs", ""); !strings.Contains(string(body), "second") {
+		t.Errorf("logs = %q", body)
+	}
+
+	url := "ws" + strings.TrimPrefix(td.server.URL, "http") + "/ws/logs"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var message struct {
+		Type      string   `json:"type"`
+		Lines     []string `json:"lines"`
+		Timestamp int64    `json:"timestamp"`
+	}
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != "initial_logs" || !reflect.DeepEqual(message.Lines, []string{"first", "second"}) || message.Timestamp != 1700000000 {
+		t.Errorf("got %+v", message)
+	}
+}
+
+func TestRestartServiceRunsSystemctl(t *testing.T) {
+	td := newTestDashboard(t)
+
+	_, body := td.do(t, "POST", "/api/restart/nfq", "")
+	var response map[string]interface{}
+	decodeJSON(t, body, &response)
+	if response["success"] != true {
+		t.Errorf("response = %v", response)
+	}
+	want := [][]string{{"sudo", "systemctl", "restart", "ips"}}
+	if !reflect.DeepEqual(td.runner.calls, want) {
+		t.Errorf("calls = %v, want %v", td.runner.calls, want)
+	}
+
+	td.runner.err = errors.New("exit status 1")
+	_, body = td.do(t, "POST", "/api/restart/web", "")
+	decodeJSON(t, body, &response)
+	if response["success"] != false || response["error"] != "exit status 1" {
+		t.Errorf("response = %v", response)
+	}
+
+	if status, _ := td.do(t, "POST", "/api/restart/sshd", ""); status != http.StatusBadRequest {
+		t.Errorf("unknown service: status %d, want 400", status)
+	}
+}
+
+func TestConfigAPIValidatesAgainstSchema(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "nfq/config.schema.json", `{
+		"type": "object",
+		"required": ["queue"],
+		"additionalProperties": false,
+		"properties": {
+			"queue": {"type": "integer", "minimum": 0},
+			"mode": {"enum": ["ips", "ids"]}
+		}
+	}`)
+
+	content := "{\n  \"queue\": -1,\n  \"mode\": \"ips\",\n  \"typo\": true\n}"
+	status, body := td.do(t, "POST", "/api/config", content)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", status, body)
+	}
+
+	var response struct {
+		Errors []schemaError `json:"errors"`
+	}
+	decodeJSON(t, body, &response)
+	if len(response.Errors) != 2 {
+		t.Fatalf("errors = %+v", response.Errors)
+	}
+	if e := response.Errors[0]; e.Pointer != "/queue" || e.Line != 2 || e.Column != 12 {
+		t.Errorf("first error = %+v", e)
+	}
+	if e := response.Errors[1]; e.Pointer != "/typo" || e.Line != 4 || e.Column != 11 {
+		t.Errorf("second error = %+v", e)
+	}
+
+	status, body = td.do(t, "POST", "/api/config", "{\n  \"queue\": 1,\n}")
+	decodeJSON(t, body, &response)
+	if status != http.StatusUnprocessableEntity || len(response.Errors) != 1 || response.Errors[0].Line != 3 {
+		t.Errorf("syntax error: status %d, errors %+v", status, response.Errors)
+	}
+
+	for _, tc := range []struct {
+		content string
+		column  int
+	}{
+		{`{"a":1}}`, 8},
+		{`{"a":1} xyz`, 9},
+		{`{"a":1},`, 8},
+		{"{\"a\":1}\n\n[]", 1},
+	} {
+		status, body = td.do(t, "POST", "/api/config", tc.content)
+		response.Errors = nil
+		decodeJSON(t, body, &response)
+		if status != http.StatusUnprocessableEntity || len(response.Errors) != 1 || response.Errors[0].Column != tc.column {
+			t.Errorf("trailing data %q: status %d, errors %+v", tc.content, status, response.Errors)
+		}
+	}
+
+	if status, body = td.do(t, "POST", "/api/config?force=true", content); status != http.StatusOK {
+		t.Fatalf("forced save: status %d: %s", status, body)
+	}
+	if _, body = td.do(t, "GET", "/api/config", ""); string(body) != content {
+		t.Errorf("saved %q, want %q", body, content)
+	}
+}
+
+func TestStatsWebSocketSharesSampler(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.cpu = 12
+
+	url := "ws" + strings.TrimPrefix(td.server.URL, "http") + "/ws/stats"
+	var conns []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+
+		var stats SystemStats
+		if err := conn.ReadJSON(&stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	td.sampleStats()
+	for _, conn := range conns {
+		var stats SystemStats
+		if err := conn.ReadJSON(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.CPU != 12 {
+			t.Errorf("cpu = %v, want 12", stats.CPU)
+		}
+	}
+
+	if n := td.statsHub.Subscribers(); n != 3 {
+		t.Errorf("subscribers = %d, want 3", n)
+	}
+}
+
+func TestStatsWebSocketPushesPacketStats(t *testing.T) {
+	td := newTestDashboard(t)
+
+	url := "ws" + strings.TrimPrefix(td.server.URL, "http") + "/ws/stats"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var stats SystemStats
+	if err := conn.ReadJSON(&stats); err != nil {
+		t.Fatal(err)
+	}
+
+	// A burst of rewrites must end with the last one delivered, without
+	// a message per write. Pushes after the first wait for the clock.
+	for total := 10; total <= 50; total += 10 {
+		td.writeFile(t, "tmp/nfq.json", fmt.Sprintf(`{"total": %d, "passed": %d, "blocked": 0}`, total, total))
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messages := 0
+	for {
+		var message packetStatsMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("after %d messages: %v", messages, err)
+		}
+		if message.Type != "packet_stats" || message.Stats.PacketStats == nil {
+			t.Fatalf("unexpected message %+v", message)
+		}
+		messages++
+		if message.Stats.Total == 50 {
+			break
+		}
+		td.clock.advance(packetPushInterval)
+	}
+	if messages > 2 {
+		t.Errorf("%d messages for one burst of writes", messages)
+	}
+
+	td.clock.advance(packetPushInterval)
+	if err := os.Remove(filepath.Join(td.root, "tmp/nfq.json")); err != nil {
+		t.Fatal(err)
+	}
+	var message packetStatsMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Stats.Status != sourceUnavailable || message.Stats.PacketStats != nil {
+		t.Errorf("after removal got %+v", message.Stats)
+	}
+}
+
+func TestStatsHistoryDownsamples(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "tmp/nfq.json", `{"total": 10, "passed": 8, "blocked": 2}`)
+
+	for _, cpu := range []float64{10, 30, 50, 70} {
+		td.clock.advance(10 * time.Second)
+		td.stats.cpu = cpu
+		td.sampleStats()
+	}
+
+	status, body := td.do(t, "GET", "/api/stats/history?metric=cpu&range=1m&step=20s", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var response struct {
+		Step   int64          `json:"step"`
+		Points []historyPoint `json:"points"`
+	}
+	decodeJSON(t, body, &response)
+
+	// The startup sample at ...000 (cpu 0) and samples at ...010 to ...040
+	// fall into the 20 s buckets 000, 020 and 040.
+	want := []historyPoint{
+		{Time: 1700000000, Avg: 5, Min: 0, Max: 10, Count: 2},
+		{Time: 1700000020, Avg: 40, Min: 30, Max: 50, Count: 2},
+		{Time: 1700000040, Avg: 70, Min: 70, Max: 70, Count: 1},
+	}
+	if response.Step != 20 || !reflect.DeepEqual(response.Points, want) {
+		t.Errorf("got step %d, points %+v", response.Step, response.Points)
+	}
+
+	_, body = td.do(t, "GET", "/api/stats/history?metric=blocked&range=1m&step=1m", "")
+	decodeJSON(t, body, &response)
+	count := 0
+	for _, p := range response.Points {
+		count += p.Count
+		if p.Min != 2 || p.Max != 2 {
+			t.Errorf("blocked point = %+v", p)
+		}
+	}
+	// The startup sample was taken before nfq.json existed.
+	if count != 4 {
+		t.Errorf("blocked samples = %d, want 4", count)
+	}
+
+	// Ranges beyond the in-memory retention come from the on-disk store.
+	var stored struct {
+		Source string         `json:"source"`
+		Points []historyPoint `json:"points"`
+	}
+	_, body = td.do(t, "GET", "/api/stats/history?metric=cpu&range=24h&step=1h", "")
+	decodeJSON(t, body, &stored)
+	if stored.Source != "10s" || len(stored.Points) != 1 || stored.Points[0].Count != 5 || stored.Points[0].Max != 70 {
+		t.Errorf("stored history from %q: %+v", stored.Source, stored.Points)
+	}
+
+	if status, _ := td.do(t, "GET", "/api/stats/history?metric=ram_used&range=24h&step=1h", ""); status != http.StatusBadRequest {
+		t.Errorf("memory-only metric beyond 6h: status %d, want 400", status)
+	}
+
+	// Without the store the ring cannot serve more than its own retention.
+	td.store.Close()
+	td.store = nil
+	if status, body := td.do(t, "GET", "/api/stats/history?metric=cpu&range=24h&step=1h", ""); status != http.StatusBadRequest {
+		t.Errorf("range beyond memory without store: status %d, want 400: %s", status, body)
+	}
+	if status, _ := td.do(t, "GET", "/api/stats/history?metric=cpu&range=6h&step=1h", ""); status != http.StatusOK {
+		t.Errorf("in-memory range without store: status %d, want 200", status)
+	}
+
+	if status, _ := td.do(t, "GET", "/api/stats/history?metric=nope", ""); status != http.StatusBadRequest {
+		t.Errorf("unknown metric: status %d, want 400", status)
+	}
+	if status, _ := td.do(t, "GET", "/api/stats/history?metric=cpu&range=24h&step=1s", ""); status != http.StatusBadRequest {
+		t.Errorf("too many points: status %d, want 400", status)
+	}
+}
+
+func TestStatsHistorySurvivesRestart(t *testing.T) {
+	td := newTestDashboard(t)
+	for _, cpu := range []float64{10, 30, 50} {
+		td.clock.advance(10 * time.Second)
+		td.stats.cpu = cpu
+		td.sampleStats()
+	}
+
+	td.clock.advance(time.Minute)
+	td.stats.cpu = 90
+	td.reopen(t)
+
+	status, body := td.do(t, "GET", "/api/stats/history?metric=cpu&range=1h&step=10s", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var response struct {
+		Source string         `json:"source"`
+		Points []historyPoint `json:"points"`
+	}
+	decodeJSON(t, body, &response)
+
+	// The 10 s buckets from before the restart come back from 10s.rrd, the
+	// last point is the new dashboard's startup sample.
+	var avgs []float64
+	for _, p := range response.Points {
+		avgs = append(avgs, p.Avg)
+	}
+	if want := []float64{0, 10, 30, 50, 90}; response.Source != "memory" || !reflect.DeepEqual(avgs, want) {
+		t.Errorf("history after restart from %q: %v, want %v", response.Source, avgs, want)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	td := newTestDashboard(t)
+	td.stats.cpu = 12.5
+	td.stats.setCounters(
+		net.IOCountersStat{Name: "wan0", BytesRecv: 4000, PacketsSent: 7},
+		net.IOCountersStat{Name: "lan0", BytesRecv: 100},
+	)
+	td.writeFile(t, "tmp/nfq.json", `{"total": 30, "passed": 20, "blocked": 10, "protocols": {"udp": {"packets": 5}}, "rules": {"rule_1": {"hits": 9}}}`)
+	td.sampleStats()
+
+	status, body := td.do(t, "GET", "/metrics", "")
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	for _, line := range []string{
+		"# TYPE gex_cpu_usage_percent gauge",
+		"gex_cpu_usage_percent 12.5",
+		"# TYPE gex_network_receive_bytes_total counter",
+		`gex_network_receive_bytes_total{interface="lan0"} 100`,
+		`gex_network_receive_bytes_total{interface="wan0"} 4000`,
+		`gex_network_transmit_packets_total{interface="wan0"} 7`,
+		"gex_nfq_stats_up 1",
+		"gex_nfq_packets_blocked_total 10",
+		`gex_nfq_protocol_packets_total{protocol="udp"} 5`,
+		`gex_nfq_rule_hits_total{rule="rule_1"} 9`,
+		"gex_engine_stats_up 0",
+		`gex_log_watcher_file_present{path="` + filepath.Join(td.root, "nfq/log.txt") + `"} 0`,
+		`gex_websocket_clients{stream="logs"} 0`,
+		`gex_websocket_clients{stream="stats"} 0`,
+		`gex_websocket_clients{stream="alerts"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestAlertsMoveThroughStates(t *testing.T) {
+	td := newTestDashboard(t)
+	td.writeFile(t, "alerts.json", `{"rules": [
+		{"name": "cpu_hot", "expr": "cpu > 90 for 4s", "severity": "warning"},
+		{"name": "block_spike", "expr": "blocked_rate > 10/s", "severity": "critical"},
+		{"name": "blocked_high", "expr": "blocked > 50"}
+	]}`)
+
+	url := "ws" + strings.TrimPrefix(td.server.URL, "http") + "/ws/alerts"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var initial struct {
+		Type string `json:"type"`
+	}
+	if err := conn.ReadJSON(&initial); err != nil || initial.Type != "alerts" {
+		t.Fatalf("initial message %+v, %v", initial, err)
+	}
+	if _, body := td.do(t, "GET", "/metrics", ""); !strings.Contains(string(body), `gex_websocket_clients{stream="alerts"} 1`+"\n") {
+		t.Errorf("alerts client not counted:\n%s", body)
+	}
+
+	step := func(cpu float64, blocked int) {
+		td.clock.advance(2 * time.Second)
+		td.stats.cpu = cpu
+		td.writeFile(t, "tmp/nfq.json", fmt.Sprintf(`{"total": %d, "passed": 0, "blocked": %d}`, blocked, blocked))
+		td.sampleStats()
+	}
+	step(95, 0)   // cpu_hot pending
+	step(95, 100) // cpu_hot still pending (2s), block_spike and blocked_high firing
+	step(95, 100) // cpu_hot firing (4s), block_spike resolved
+	step(50, 100) // cpu_hot resolved
+
+	readTransitions := func(n int) []string {
+		var transitions []string
+		for i := 0; i < n; i++ {
+			var msg struct {
+				Type  string     `json:"type"`
+				Event AlertEvent `json:"event"`
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			transition := msg.Event.Rule + ":" + msg.Event.Previous + ">" + msg.Event.State
+			if msg.Event.NoData {
+				transition += " (no data)"
+			}
+			transitions = append(transitions, transition)
+		}
+		return transitions
+	}
+	want := []string{
+		"cpu_hot:inactive>pending",
+		"block_spike:inactive>firing",
+		"blocked_high:inactive>firing",
+		"cpu_hot:pending>firing",
+		"block_spike:firing>resolved",
+		"cpu_hot:firing>resolved",
+	}
+	if transitions := readTransitions(len(want)); !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v", transitions)
+	}
+
+	_, body := td.do(t, "GET", "/api/alerts", "")
+	var response struct {
+		Alerts []AlertStatus `json:"alerts"`
+		Events []AlertEvent  `json:"events"`
+	}
+	decodeJSON(t, body, &response)
+	if len(response.Alerts) != 3 || response.Alerts[0].State != alertResolved || response.Alerts[0].FiredAt != 1700000006 ||
+		*response.Alerts[0].Value != 50 || len(response.Events) != 6 {
+		t.Errorf("got %+v", response)
+	}
+
+	// Without the stats file blocked_high keeps firing until the metric
+	// has been missing for alertNoDataTimeout.
+	if err := os.Remove(filepath.Join(td.root, "tmp/nfq.json")); err != nil {
+		t.Fatal(err)
+	}
+	td.clock.advance(2 * time.Second)
+	td.sampleStats()
+	_, body = td.do(t, "GET", "/api/alerts", "")
+	response.Alerts = nil
+	decodeJSON(t, body, &response)
+	if a := response.Alerts[2]; a.State != alertFiring || a.NoData || a.LastSeen != 1700000008 {
+		t.Errorf("blocked_high right after the file vanished: %+v", a)
+	}
+	td.clock.advance(alertNoDataTimeout)
+	td.sampleStats()
+	if transitions := readTransitions(1); !reflect.DeepEqual(transitions, []string{"blocked_high:firing>resolved (no data)"}) {
+		t.Errorf("no data transitions = %v", transitions)
+	}
+	_, body = td.do(t, "GET", "/api/alerts", "")
+	response.Alerts = nil
+	decodeJSON(t, body, &response)
+	if a := response.Alerts[2]; a.State != alertResolved || !a.NoData {
+		t.Errorf("blocked_high without data: %+v", a)
+	}
+	if a := response.Alerts[0]; a.State != alertResolved || a.NoData {
+		t.Errorf("cpu_hot still has data: %+v", a)
+	}
+
+	// A broken file keeps the previous rules and reports the error.
+	td.writeFile(t, "alerts.json", `{"rules": [{"name": "x", "expr": "cpu >"}]}`)
+	step(50, 100)
+	_, body = td.do(t, "GET", "/api/alerts", "")
+	var broken struct {
+		Alerts []AlertStatus `json:"alerts"`
+		Error  string        `json:"error"`
+	}
+	decodeJSON(t, body, &broken)
+	if len(broken.Alerts) != 3 || broken.Error == "" {
+		t.Errorf("broken file: %+v", broken)
+	}
+}
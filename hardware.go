@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Temperature is one sensor reading in degrees Celsius.
+type Temperature struct {
+	Sensor   string  `json:"sensor"`
+	Celsius  float64 `json:"celsius"`
+	High     float64 `json:"high,omitempty"`
+	Critical float64 `json:"critical,omitempty"`
+}
+
+// CoreStats holds the usage of one CPU core and its frequencies in MHz;
+// MaxFreq is the current policy limit, HardwareMaxFreq what the core supports.
+type CoreStats struct {
+	Core            int     `json:"core"`
+	Usage           float64 `json:"usage"`
+	Freq            float64 `json:"freqMHz,omitempty"`
+	MaxFreq         float64 `json:"maxFreqMHz,omitempty"`
+	HardwareMaxFreq float64 `json:"hardwareMaxFreqMHz,omitempty"`
+}
+
+type LoadStats struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// ThrottleStats reports whether the board is being slowed down and why:
+// "temperature" (a sensor at or above its high mark), "cooling" (an active
+// thermal cooling device) or "frequency_cap" (policy max below hardware max).
+type ThrottleStats struct {
+	Throttled bool     `json:"throttled"`
+	Reasons   []string `json:"reasons,omitempty"`
+}
+
+const (
+	thermalDir = "/sys/class/thermal"
+	cpuDir     = "/sys/devices/system/cpu"
+)
+
+// collectHardware fills the hardware health fields of stats. Sensors and
+// cpufreq are often missing (VMs, containers), so failures only drop the
+// affected fields.
+func (d *Dashboard) collectHardware(stats *SystemStats) {
+	if temps, err := d.stats.Temperatures(); err != nil && len(temps) == 0 {
+		slog.Debug("Failed to read temperatures", "error", err)
+	} else {
+		for _, t := range temps {
+			stats.Temperatures = append(stats.Temperatures, Temperature{
+				Sensor:   t.SensorKey,
+				Celsius:  t.Temperature,
+				High:     t.High,
+				Critical: t.Critical,
+			})
+		}
+		sort.Slice(stats.Temperatures, func(i, j int) bool {
+			return stats.Temperatures[i].Sensor < stats.Temperatures[j].Sensor
+		})
+	}
+
+	if usage, err := d.stats.PerCoreCPUPercent(); err != nil {
+		slog.Debug("Failed to read per-core CPU usage", "error", err)
+	} else {
+		for i, u := range usage {
+			freq := d.path(filepath.Join(cpuDir, fmt.Sprintf("cpu%d", i), "cpufreq"))
+			stats.Cores = append(stats.Cores, CoreStats{
+				Core:            i,
+				Usage:           u,
+				Freq:            readSysfsInt(filepath.Join(freq, "scaling_cur_freq")) / 1000,
+				MaxFreq:         readSysfsInt(filepath.Join(freq, "scaling_max_freq")) / 1000,
+				HardwareMaxFreq: readSysfsInt(filepath.Join(freq, "cpuinfo_max_freq")) / 1000,
+			})
+		}
+	}
+
+	if avg, err := d.stats.LoadAvg(); err != nil {
+		slog.Debug("Failed to read load average", "error", err)
+	} else {
+		stats.Load = LoadStats{Load1: avg.Load1, Load5: avg.Load5, Load15: avg.Load15}
+	}
+
+	if uptime, err := d.stats.Uptime(); err != nil {
+		slog.Debug("Failed to read uptime", "error", err)
+	} else {
+		stats.Uptime = uptime
+	}
+
+	stats.Throttling = d.throttling(stats)
+}
+
+func (d *Dashboard) throttling(stats *SystemStats) ThrottleStats {
+	var reasons []string
+
+	for _, t := range stats.Temperatures {
+		if t.High > 0 && t.Celsius >= t.High {
+			reasons = append(reasons, "temperature")
+			break
+		}
+	}
+
+	devices, _ := filepath.Glob(filepath.Join(d.path(thermalDir), "cooling_device*"))
+	for _, dev := range devices {
+		if isFrequencyCooling(dev) && readSysfsInt(filepath.Join(dev, "cur_state")) > 0 {
+			reasons = append(reasons, "cooling")
+			break
+		}
+	}
+
+	for _, core := range stats.Cores {
+		if core.MaxFreq > 0 && core.HardwareMaxFreq > 0 && core.MaxFreq < core.HardwareMaxFreq {
+			reasons = append(reasons, "frequency_cap")
+			break
+		}
+	}
+
+	return ThrottleStats{Throttled: len(reasons) > 0, Reasons: reasons}
+}
+
+// isFrequencyCooling tells cooling devices that slow the CPU or a devfreq
+// device down from fans, whose non-zero state is just normal cooling.
+func isFrequencyCooling(dev string) bool {
+	data, err := os.ReadFile(filepath.Join(dev, "type"))
+	if err != nil {
+		return false
+	}
+	kind := strings.TrimSpace(string(data))
+	return kind == "Processor" ||
+		strings.HasPrefix(kind, "cpufreq-") ||
+		strings.HasPrefix(kind, "thermal-cpufreq-") ||
+		strings.HasPrefix(kind, "devfreq-")
+}
+
+// readSysfsInt returns the integer in a sysfs file, or 0 if it is missing.
+func readSysfsInt(path string) float64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return float64(v)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historyRetention     = 6 * time.Hour
+	maxHistoryPoints     = 1000
+	defaultHistoryRange  = time.Hour
+	defaultHistoryPoints = 120
+)
+
+// historyMetrics maps the metric names accepted by /api/stats/history to
+// the value they read from the collected stats.
+var historyMetrics = map[string]func(s *SystemStats) (float64, bool){
+	"cpu":                systemMetric(func(s *SystemStats) float64 { return s.CPU }),
+	"ram":                systemMetric(func(s *SystemStats) float64 { return s.RAM }),
+	"ram_used":           systemMetric(func(s *SystemStats) float64 { return float64(s.RAMUsed) }),
+	"disk":               systemMetric(func(s *SystemStats) float64 { return s.Disk }),
+	"disk_used":          systemMetric(func(s *SystemStats) float64 { return float64(s.DiskUsed) }),
+	"download":           interfaceMetric(func(s *SpeedStats) float64 { return s.Download }),
+	"upload":             interfaceMetric(func(s *SpeedStats) float64 { return s.Upload }),
+	"packets_in":         interfaceMetric(func(s *SpeedStats) float64 { return s.PacketsIn }),
+	"packets_out":        interfaceMetric(func(s *SpeedStats) float64 { return s.PacketsOut }),
+	"total":              packetMetric(func(s *PacketStats) float64 { return float64(s.Total) }),
+	"passed":             packetMetric(func(s *PacketStats) float64 { return float64(s.Passed) }),
+	"blocked":            packetMetric(func(s *PacketStats) float64 { return float64(s.Blocked) }),
+	"total_rate":         packetRateMetric(func(s *PacketRates) float64 { return s.Total }),
+	"passed_rate":        packetRateMetric(func(s *PacketRates) float64 { return s.Passed }),
+	"blocked_rate":       packetRateMetric(func(s *PacketRates) float64 { return s.Blocked }),
+	"block_ratio":        packetRateMetric(func(s *PacketRates) float64 { return s.BlockRatio }),
+	"disk_read":          systemMetric(func(s *SystemStats) float64 { return sumDiskIO(s, diskReadBytes) }),
+	"disk_write":         systemMetric(func(s *SystemStats) float64 { return sumDiskIO(s, diskWriteBytes) }),
+	"disk_iops":          systemMetric(func(s *SystemStats) float64 { return sumDiskIO(s, diskIOPS) }),
+	"load1":              systemMetric(func(s *SystemStats) float64 { return s.Load.Load1 }),
+	"load5":              systemMetric(func(s *SystemStats) float64 { return s.Load.Load5 }),
+	"load15":             systemMetric(func(s *SystemStats) float64 { return s.Load.Load15 }),
+	"nfq_cpu":            processMetric("nfq", func(p *ProcessStats) float64 { return p.CPU }),
+	"nfq_rss":            processMetric("nfq", func(p *ProcessStats) float64 { return float64(p.RSS) }),
+	"web_cpu":            processMetric("web", func(p *ProcessStats) float64 { return p.CPU }),
+	"web_rss":            processMetric("web", func(p *ProcessStats) float64 { return float64(p.RSS) }),
+	"temp_max":           maxTemperature,
+	"cpu_freq":           averageFrequency,
+	"throttled":          systemMetric(func(s *SystemStats) float64 { return boolValue(s.Throttling.Throttled) }),
+	"engine_cpu":         engineMetric((*EngineStats).cpuPercent),
+	"engine_memory":      engineMetric((*EngineStats).memoryBytes),
+	"queue_depth":        engineMetric((*EngineStats).queueDepth),
+	"queue_latency":      engineMetric((*EngineStats).queueLatencyAvg),
+	"queue_latency_p99":  engineMetric((*EngineStats).queueLatencyP99),
+	"worker_utilization": engineMetric((*EngineStats).workerUtilization),
+}
+
+func systemMetric(get func(s *SystemStats) float64) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		return get(s), true
+	}
+}
+
+// packetRateMetric reads the NFQ rates between the last two samples.
+func packetRateMetric(get func(s *PacketRates) float64) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		if s.PacketRates == nil || s.PacketRates.Current.Window == 0 {
+			return 0, false
+		}
+		return get(&s.PacketRates.Current), true
+	}
+}
+
+// engineMetric reads what the NFQ engine reports about itself. Unlike the
+// packet counters these are gauges, so a stale file is not recorded.
+func engineMetric(get func(e *EngineStats) (float64, bool)) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		if s.Engine == nil || s.EngineSource == nil || s.EngineSource.Stale {
+			return 0, false
+		}
+		return get(s.Engine)
+	}
+}
+
+// processMetric reads a service's process while it is running.
+func processMetric(service string, get func(p *ProcessStats) float64) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		for i := range s.Processes {
+			if p := &s.Processes[i]; p.Service == service && p.PID > 0 && p.Error == "" {
+				return get(p), true
+			}
+		}
+		return 0, false
+	}
+}
+
+func diskReadBytes(r DiskIOStats) float64  { return r.ReadBytes }
+func diskWriteBytes(r DiskIOStats) float64 { return r.WriteBytes }
+func diskIOPS(r DiskIOStats) float64       { return r.ReadIOPS + r.WriteIOPS }
+
+func sumDiskIO(s *SystemStats, get func(r DiskIOStats) float64) float64 {
+	var sum float64
+	for _, r := range s.DiskIO {
+		sum += get(r)
+	}
+	return sum
+}
+
+func maxTemperature(s *SystemStats) (float64, bool) {
+	if len(s.Temperatures) == 0 {
+		return 0, false
+	}
+	max := s.Temperatures[0].Celsius
+	for _, t := range s.Temperatures[1:] {
+		max = math.Max(max, t.Celsius)
+	}
+	return max, true
+}
+
+func averageFrequency(s *SystemStats) (float64, bool) {
+	var sum float64
+	var n int
+	for _, core := range s.Cores {
+		if core.Freq > 0 {
+			sum += core.Freq
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// interfaceMetric reads the rates of the configured primary interface.
+func interfaceMetric(get func(s *SpeedStats) float64) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		speed, ok := s.Interfaces[s.Interface]
+		if !ok {
+			return 0, false
+		}
+		return get(&speed), true
+	}
+}
+
+func packetMetric(get func(s *PacketStats) float64) func(s *SystemStats) (float64, bool) {
+	return func(s *SystemStats) (float64, bool) {
+		if s.Packets == nil {
+			return 0, false
+		}
+		return get(s.Packets), true
+	}
+}
+
+// historyColumns orders the values of a sample; NaN marks a metric the
+// stats did not have.
+var historyColumns = historyMetricNames()
+
+func historyColumn(metric string) (int, bool) {
+	i := sort.SearchStrings(historyColumns, metric)
+	return i, i < len(historyColumns) && historyColumns[i] == metric
+}
+
+// historySample is every history metric read once from the collected
+// stats, for the ring, the persistent store and the alerts.
+type historySample struct {
+	Time   int64
+	Values []float64
+}
+
+func newHistorySample(stats *SystemStats) *historySample {
+	sample := &historySample{Time: stats.Timestamp, Values: make([]float64, len(historyColumns))}
+	for i, name := range historyColumns {
+		v, ok := historyMetrics[name](stats)
+		if !ok {
+			v = math.NaN()
+		}
+		sample.Values[i] = v
+	}
+	return sample
+}
+
+// historyRow is how the ring keeps a sample: float32 is plenty for charts
+// and keeps six hours of samples within the service's memory limit.
+type historyRow struct {
+	Time   int64
+	Values []float32
+}
+
+func (s *historySample) row() historyRow {
+	row := historyRow{Time: s.Time, Values: make([]float32, len(s.Values))}
+	for i, v := range s.Values {
+		row.Values[i] = float32(v)
+	}
+	return row
+}
+
+// metricsHistory is a fixed-size ring buffer of rows, oldest first.
+type metricsHistory struct {
+	mu   sync.RWMutex
+	rows []historyRow
+	next int
+	full bool
+}
+
+func newMetricsHistory(size int) *metricsHistory {
+	if size < 1 {
+		size = 1
+	}
+	return &metricsHistory{rows: make([]historyRow, size)}
+}
+
+func (h *metricsHistory) Add(row historyRow) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rows[h.next] = row
+	h.next = (h.next + 1) % len(h.rows)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *metricsHistory) each(fn func(row *historyRow)) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.full {
+		for i := h.next; i < len(h.rows); i++ {
+			fn(&h.rows[i])
+		}
+	}
+	for i := 0; i < h.next; i++ {
+		fn(&h.rows[i])
+	}
+}
+
+type historyPoint struct {
+	Time  int64   `json:"t"`
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// Query aggregates metric over [from, to] into step-aligned buckets.
+// Buckets without samples are omitted.
+func (h *metricsHistory) Query(metric string, from, to int64, step int64) ([]historyPoint, error) {
+	column, ok := historyColumn(metric)
+	if !ok {
+		return nil, fmt.Errorf("unknown metric %q", metric)
+	}
+
+	buckets := make(map[int64]*historyPoint)
+	h.each(func(row *historyRow) {
+		if row.Time < from || row.Time > to {
+			return
+		}
+		value := float64(row.Values[column])
+		if math.IsNaN(value) {
+			return
+		}
+
+		start := row.Time - row.Time%step
+		p, ok := buckets[start]
+		if !ok {
+			p = &historyPoint{Time: start, Min: math.Inf(1), Max: math.Inf(-1)}
+			buckets[start] = p
+		}
+		p.Avg += value
+		p.Min = math.Min(p.Min, value)
+		p.Max = math.Max(p.Max, value)
+		p.Count++
+	})
+
+	points := make([]historyPoint, 0, len(buckets))
+	for _, p := range buckets {
+		p.Avg /= float64(p.Count)
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+	return points, nil
+}
+
+func historyMetricNames() []string {
+	names := make([]string, 0, len(historyMetrics))
+	for name := range historyMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *Dashboard) recordHistory(stats *SystemStats) *historySample {
+	sample := newHistorySample(stats)
+	d.history.Add(sample.row())
+	if d.store != nil {
+		d.store.Add(sample)
+	}
+	return sample
+}
+
+// persistentMetrics are the history metrics also written to disk. Every
+// metric costs about 1 MB across the tiers and adding one rewrites the
+// files, so the list is fixed; new metrics stay in memory unless added
+// here. Cumulative counters, derived values and the dashboard's own
+// process are left out.
+var persistentMetrics = []string{
+	"block_ratio", "blocked_rate", "cpu", "cpu_freq", "disk", "disk_read",
+	"disk_write", "download", "load1", "nfq_cpu", "nfq_rss", "packets_in",
+	"packets_out", "passed_rate", "queue_latency", "ram", "temp_max",
+	"throttled", "total_rate", "upload",
+}
+
+func isPersistentMetric(metric string) bool {
+	for _, name := range persistentMetrics {
+		if name == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsDir is where the persistent store lives; by default next to
+// config.json so it survives reboots unlike /tmp.
+func (d *Dashboard) metricsDir() string {
+	if dir := getConfig().MetricsDir; dir != "" {
+		return d.path(dir)
+	}
+	return d.path(filepath.Join(filepath.Dir(configFile), "metrics"))
+}
+
+// openMetricsStore enables persistent history. Failing to open it is not
+// fatal: the dashboard keeps the in-memory history only.
+func (d *Dashboard) openMetricsStore() {
+	dir := d.metricsDir()
+	store, err := openMetricsStore(dir, persistentMetrics)
+	if err != nil {
+		slog.Warn("Persistent metrics history disabled", "dir", dir, "error", err)
+		return
+	}
+	d.store = store
+	d.restoreHistory()
+}
+
+// restoreHistory fills the ring from the store after a restart so that
+// short ranges are not empty until new samples come in. Restored rows are
+// 10 s averages rather than single samples.
+func (d *Dashboard) restoreHistory() {
+	to := d.clock.Now().Unix()
+	rows, err := d.store.Rows(to-int64(historyRetention/time.Second), to)
+	if err != nil {
+		slog.Warn("Failed to restore metrics history", "error", err)
+		return
+	}
+	for _, row := range rows {
+		d.history.Add(row)
+	}
+	if len(rows) > 0 {
+		slog.Info("Metrics history restored", "rows", len(rows))
+	}
+}
+
+func (d *Dashboard) statsHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	fail := func(message string) {
+		response := map[string]interface{}{
+			"success": false,
+			"error":   message,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(response)
+	}
+
+	metric := query.Get("metric")
+	if _, ok := historyMetrics[metric]; !ok {
+		fail("Неизвестная метрика " + metric + ", доступны: " + strings.Join(historyMetricNames(), ", "))
+		return
+	}
+
+	span := defaultHistoryRange
+	if v := query.Get("range"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			fail("Некорректный range: " + v)
+			return
+		}
+		span = parsed
+	}
+
+	step := span / defaultHistoryPoints
+	if v := query.Get("step"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			fail("Некорректный step: " + v)
+			return
+		}
+		step = parsed
+	}
+	if step < time.Second {
+		step = time.Second
+	}
+	if span/step > maxHistoryPoints {
+		fail(fmt.Sprintf("Слишком много точек: range/step должно быть не больше %d", maxHistoryPoints))
+		return
+	}
+
+	to := d.clock.Now().Unix()
+	from := to - int64(span/time.Second)
+	stepSeconds := int64(step / time.Second)
+
+	source := "memory"
+	var points []historyPoint
+	var err error
+	if span > historyRetention {
+		if d.store == nil {
+			fail(fmt.Sprintf("range не может превышать %s: постоянное хранилище метрик отключено", historyRetention))
+			return
+		}
+		if !isPersistentMetric(metric) {
+			fail(fmt.Sprintf("range не может превышать %s: метрика %s хранится только в памяти", historyRetention, metric))
+			return
+		}
+		if to-from > d.store.Retention() {
+			fail(fmt.Sprintf("range не может превышать %s", time.Duration(d.store.Retention())*time.Second))
+			return
+		}
+		points, source, stepSeconds, err = d.store.Query(metric, from, to, stepSeconds)
+	} else {
+		points, err = d.history.Query(metric, from, to, stepSeconds)
+	}
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"metric":  metric,
+		"from":    from,
+		"to":      to,
+		"step":    stepSeconds,
+		"source":  source,
+		"points":  points,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -14,7 +14,7 @@ if ! id "$SERVICE_USER" &>/dev/null; then
 fi
 
 echo "Создание директорий..."
-mkdir -p $INSTALL_DIR/rules
+mkdir -p $INSTALL_DIR/rules $INSTALL_DIR/metrics
 chown -R $SERVICE_USER:$SERVICE_USER $INSTALL_DIR
 
 echo "Копирование исполнимого файла..."
@@ -22,27 +22,49 @@ cp gex-dashboard $INSTALL_DIR/
 chmod +x $INSTALL_DIR/gex-dashboard
 chown $SERVICE_USER:$SERVICE_USER $INSTALL_DIR/gex-dashboard
 
-echo "Копирование веб-интерфейса..."
-cp -r static $INSTALL_DIR/
-chown -R $SERVICE_USER:$SERVICE_USER $INSTALL_DIR/static
-
 if [ ! -f "$INSTALL_DIR/config.json" ]; then
     echo "Создание конфигурационного файла..."
     cat > $INSTALL_DIR/config.json << EOF
 {
-    "interface": "lan0",    
+    "version": 2,
+    "interface": "lan0",
     "nfq_log_file": "/root/nfq/log.txt",
     "nfq_config_file": "/root/nfq/config.json",
     "nfq_rules_dir": "/root/nfq/rules",
     "net_stats_file": "/tmp/nfq/nfq.json",
     "sys_stats_file": "/tmp/nfq/sys.json",
-    "log_level": "info",
-    "listen_port": "$DASHBOARD_PORT"
+    "logLevel": "info",
+    "listenPort": "$DASHBOARD_PORT"
 }
 EOF
     chown $SERVICE_USER:$SERVICE_USER $INSTALL_DIR/config.json
 fi
 
+if [ ! -f "$INSTALL_DIR/alerts.json" ]; then
+    echo "Создание правил оповещений..."
+    cat > $INSTALL_DIR/alerts.json << EOF
+{
+    "rules": [
+        {"name": "cpu_high", "expr": "cpu > 90 for 5m", "severity": "warning"},
+        {"name": "ram_high", "expr": "ram > 90 for 2m", "severity": "critical"},
+        {"name": "overheat", "expr": "temp_max > 80 for 1m", "severity": "critical"}
+    ]
+}
+EOF
+    chown $SERVICE_USER:$SERVICE_USER $INSTALL_DIR/alerts.json
+fi
+
+# Схема проверяет только то, что конфигурация NFQ — JSON-объект; описание
+# полей движка добавляется в неё вручную.
+NFQ_SCHEMA_FILE="/root/nfq/config.schema.json"
+if [ -d "$(dirname $NFQ_SCHEMA_FILE)" ] && [ ! -f "$NFQ_SCHEMA_FILE" ]; then
+    echo "Создание схемы конфигурации NFQ..."
+    cat > $NFQ_SCHEMA_FILE << EOF
+{
+    "type": "object"
+}
+EOF
+fi
 
 echo "Создание правил sudoers для перезапуска служб..."
 cat > /etc/sudoers.d/gex-services << EOF
@@ -65,7 +87,7 @@ Type=simple
 User=$SERVICE_USER
 Group=$SERVICE_USER
 WorkingDirectory=$INSTALL_DIR
-ExecStart=$INSTALL_DIR/gex-dashboard
+ExecStart=$INSTALL_DIR/gex-dashboard --config $INSTALL_DIR/config.json
 Restart=always
 RestartSec=5
 Environment=GIN_MODE=release
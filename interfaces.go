@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InterfaceInfo describes one network interface for /api/interfaces.
+type InterfaceInfo struct {
+	Name         string      `json:"name"`
+	Index        int         `json:"index"`
+	MTU          int         `json:"mtu"`
+	HardwareAddr string      `json:"hardwareAddr,omitempty"`
+	Flags        []string    `json:"flags"`
+	Addresses    []string    `json:"addresses"`
+	Up           bool        `json:"up"`
+	OperState    string      `json:"operState,omitempty"`
+	SpeedMbps    int         `json:"speedMbps,omitempty"`
+	Primary      bool        `json:"primary"`
+	Rates        *SpeedStats `json:"rates,omitempty"`
+}
+
+func (d *Dashboard) listInterfaces() ([]InterfaceInfo, error) {
+	list, err := d.stats.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var rates map[string]SpeedStats
+	if stats := d.statsHub.Latest(); stats != nil {
+		rates = stats.Interfaces
+	}
+	primary := getConfig().Interface
+
+	interfaces := make([]InterfaceInfo, 0, len(list))
+	for _, iface := range list {
+		info := InterfaceInfo{
+			Name:         iface.Name,
+			Index:        iface.Index,
+			MTU:          iface.MTU,
+			HardwareAddr: iface.HardwareAddr,
+			Flags:        iface.Flags,
+			Addresses:    []string{},
+			Primary:      iface.Name == primary,
+		}
+		if info.Flags == nil {
+			info.Flags = []string{}
+		}
+		for _, flag := range iface.Flags {
+			if flag == "up" {
+				info.Up = true
+			}
+		}
+		for _, addr := range iface.Addrs {
+			info.Addresses = append(info.Addresses, addr.Addr)
+		}
+
+		// Link state as seen by the kernel; "up" only means administratively up.
+		sys := d.path(filepath.Join("/sys/class/net", iface.Name))
+		if data, err := os.ReadFile(filepath.Join(sys, "operstate")); err == nil {
+			info.OperState = strings.TrimSpace(string(data))
+		}
+		if data, err := os.ReadFile(filepath.Join(sys, "speed")); err == nil {
+			if speed, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && speed > 0 {
+				info.SpeedMbps = speed
+			}
+		}
+
+		if r, ok := rates[iface.Name]; ok {
+			info.Rates = &r
+		}
+		interfaces = append(interfaces, info)
+	}
+
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].Name < interfaces[j].Name })
+	return interfaces, nil
+}
+
+func (d *Dashboard) interfacesHandler(w http.ResponseWriter, r *http.Request) {
+	interfaces, err := d.listInterfaces()
+	if err != nil {
+		response := map[string]interface{}{
+			"success": false,
+			"error":   "Не удалось получить список интерфейсов: " + err.Error(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(interfaces)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		remote string
+		fwd    []string
+		want   string
+	}{
+		{"203.0.113.5:4000", nil, "203.0.113.5"},
+		{"203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"127.0.0.1:4000", nil, "127.0.0.1"},
+		{"127.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:4000", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:4000", []string{"10.0.0.1", "198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:4000", []string{"10.0.0.1,"}, "127.0.0.1"},
+		{"@", []string{"10.0.0.1, 198.51.100.1"}, "198.51.100.1"},
+		{"@", nil, "unix"},
+	} {
+		r := &http.Request{RemoteAddr: tc.remote, Header: http.Header{}}
+		for _, v := range tc.fwd {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("%s %q: got %q, want %q", tc.remote, tc.fwd, got, tc.want)
+		}
+	}
+}
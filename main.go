@@ -2,131 +2,78 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/net"
 )
 
-type AppConfig struct {
-	NFQ_LOG_FILE    string `json:"nfq_log_file"`
-	NFQ_CONFIG_FILE string `json:"nfq_config_file"`
-	NFQ_RULES_DIR   string `json:"nfq_rules_dir"`
-	NET_STATS_FILE  string `json:"net_stats_file"`
-	SYS_STATS_FILE  string `json:"sys_stats_file"`
-	LogLevel        string `json:"logLevel"`
-	Interface       string `json:"interface"`
-	ListenPort      string `json:"listenPort"`
-}
-
-var config *AppConfig
-
-var prev_netstats NetworkStats
-
-const CONFIG_FILE = "./config.json"
-
-func loadConfig() (*AppConfig, error) {
-	defaultConfig := &AppConfig{
-		NFQ_LOG_FILE:    "/root/nfq/log.txt",
-		NFQ_CONFIG_FILE: "/root/nfq/config.json",
-		NFQ_RULES_DIR:   "/root/nfq/rules",
-		NET_STATS_FILE:  "/tmp/nfq/nfq.json",
-		SYS_STATS_FILE:  "/tmp/nfq/sys.json",
-		Interface:       "lan0",
-		LogLevel:        "info",
-		ListenPort:      "8080",
-	}
-
-	if _, err := os.Stat(CONFIG_FILE); os.IsNotExist(err) {
-		if err := saveConfig(defaultConfig); err != nil {
-			return nil, fmt.Errorf("failed to create default config: %v", err)
-		}
-		return defaultConfig, nil
-	}
-
-	content, err := os.ReadFile(CONFIG_FILE)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %v", err)
-	}
-
-	var cfg AppConfig
-	if err := json.Unmarshal(content, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %v", err)
-	}
-
-	if cfg.NFQ_LOG_FILE == "" {
-		cfg.NFQ_LOG_FILE = defaultConfig.NFQ_LOG_FILE
-	}
-	if cfg.NFQ_RULES_DIR == "" {
-		cfg.NFQ_RULES_DIR = defaultConfig.NFQ_RULES_DIR
-	}
-	if cfg.NET_STATS_FILE == "" {
-		cfg.NET_STATS_FILE = defaultConfig.NET_STATS_FILE
-	}
-	if cfg.SYS_STATS_FILE == "" {
-		cfg.SYS_STATS_FILE = defaultConfig.SYS_STATS_FILE
-	}
-	if cfg.Interface == "" {
-		cfg.Interface = defaultConfig.Interface
-	}
-
-	return &cfg, nil
-}
-
-func saveConfig(cfg *AppConfig) error {
-	configData, err := json.MarshalIndent(cfg, "", "    ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(CONFIG_FILE, configData, 0644)
-}
-
-type Dashboard struct {
-	upgrader      websocket.Upgrader
-	logClients    map[*websocket.Conn]bool
-	logClientsMux sync.RWMutex
-	lastLogSize   int64
-}
-
 type SystemStats struct {
-	CPU       float64    `json:"cpu"`
-	RAM       float64    `json:"ram"`
-	RAMUsed   uint64     `json:"ramUsed"`
-	RAMTotal  uint64     `json:"ramTotal"`
-	Disk      float64    `json:"disk"`
-	DiskUsed  uint64     `json:"diskUsed"`
-	DiskTotal uint64     `json:"diskTotal"`
-	Speed     SpeedStats `json:"speed"`
-	Timestamp int64      `json:"timestamp"`
+	CPU       float64                `json:"cpu"`
+	RAM       float64                `json:"ram"`
+	RAMUsed   uint64                 `json:"ramUsed"`
+	RAMTotal  uint64                 `json:"ramTotal"`
+	Disk      float64                `json:"disk"`
+	DiskUsed  uint64                 `json:"diskUsed"`
+	DiskTotal uint64                 `json:"diskTotal"`
+	Mounts    []MountStats           `json:"mounts"`
+	DiskIO    map[string]DiskIOStats `json:"diskIO"`
+	// Interface is the configured primary interface; Interfaces holds the
+	// rates of every interface by name.
+	Interface    string                `json:"interface"`
+	Interfaces   map[string]SpeedStats `json:"interfaces"`
+	Temperatures []Temperature         `json:"temperatures"`
+	Cores        []CoreStats           `json:"cores"`
+	Load         LoadStats             `json:"load"`
+	Uptime       uint64                `json:"uptime"`
+	Throttling   ThrottleStats         `json:"throttling"`
+	Processes    []ProcessStats        `json:"processes"`
+	Packets      *PacketStats          `json:"packets,omitempty"`
+	PacketRates  *PacketRateStats      `json:"packetRates,omitempty"`
+	PacketSource *SourceStatus         `json:"packetSource"`
+	Engine       *EngineStats          `json:"engine,omitempty"`
+	EngineSource *SourceStatus         `json:"engineSource"`
+	Timestamp    int64                 `json:"timestamp"`
 }
 
 type NetworkStats struct {
-	BytesRecv uint64 `json:"bytesRecv"`
-	BytesSent uint64 `json:"bytesSent"`
+	BytesRecv   uint64    `json:"bytesRecv"`
+	BytesSent   uint64    `json:"bytesSent"`
+	PacketsRecv uint64    `json:"packetsRecv"`
+	PacketsSent uint64    `json:"packetsSent"`
+	ErrIn       uint64    `json:"errIn"`
+	ErrOut      uint64    `json:"errOut"`
+	DropIn      uint64    `json:"dropIn"`
+	DropOut     uint64    `json:"dropOut"`
+	Time        time.Time `json:"-"`
 }
 
+// SpeedStats holds per-second interface rates; errors and drops are also
+// given as cumulative counters.
 type SpeedStats struct {
-	Download uint64 `json:"download"`
-	Upload   uint64 `json:"upload"`
-}
-
-type PacketStats struct {
-	Total   uint64 `json:"total"`
-	Passed  uint64 `json:"passed"`
-	Blocked uint64 `json:"blocked"`
+	Download      float64 `json:"download"`
+	Upload        float64 `json:"upload"`
+	PacketsIn     float64 `json:"packetsIn"`
+	PacketsOut    float64 `json:"packetsOut"`
+	ErrorsInRate  float64 `json:"errorsInRate"`
+	ErrorsOutRate float64 `json:"errorsOutRate"`
+	DropsInRate   float64 `json:"dropsInRate"`
+	DropsOutRate  float64 `json:"dropsOutRate"`
+	ErrorsIn      uint64  `json:"errorsIn"`
+	ErrorsOut     uint64  `json:"errorsOut"`
+	DropsIn       uint64  `json:"dropsIn"`
+	DropsOut      uint64  `json:"dropsOut"`
+	Interval      float64 `json:"interval"`
+	Reset         bool    `json:"reset,omitempty"`
 }
 
 type Rule struct {
@@ -142,114 +89,131 @@ type Rule struct {
 	Description string `json:"description"`
 }
 
-func NewDashboard() *Dashboard {
-	d := &Dashboard{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
-		},
-		logClients: make(map[*websocket.Conn]bool),
+func main() {
+	overrides, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("Invalid arguments", "error", err)
 	}
+	configFile = overrides.configFile
 
-	d.initLogWatcher()
+	cfg, err := loadEffectiveConfig(configFile, overrides, hostConfigEnv)
+	if err != nil {
+		fatal("Failed to load config", "error", err)
+	}
+	setConfig(cfg)
+	setupLogging(cfg)
 
-	return d
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func main() {
-	var err error
-	config, err = loadConfig()
+	dashboard, err := NewDashboard(ctx, DashboardOptions{
+		StaticDir: cfg.StaticDir,
+		Overrides: overrides,
+	})
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to start dashboard", "error", err)
 	}
+	dashboard.createDefaultFiles()
+	dashboard.reloader.start()
 
-	netStats, err := net.IOCounters(true)
+	// validateConfig has already rejected an unparsable socketMode.
+	mode, _ := socketMode(cfg)
+	listeners, err := openListeners(listenAddrs(cfg), mode)
 	if err != nil {
-		log.Fatal("Failed to get network stats:", err)
+		fatal("Failed to open listeners", "error", err)
 	}
 
-	var networkStats NetworkStats
-	for _, stat := range netStats {
-		if stat.Name == config.Interface {
-			networkStats = NetworkStats{
-				BytesRecv: stat.BytesRecv,
-				BytesSent: stat.BytesSent,
-			}
-			break
-		}
-	}
+	srv := newHTTPServer(accessLogMiddleware(dashboard.router()))
+	errc := serveAll(srv, listeners)
 
-	prev_netstats = networkStats
+	for _, l := range listeners {
+		slog.Info("Dashboard listening", "url", listenerURL(l))
+	}
 
-	os.MkdirAll(filepath.Dir(config.NFQ_RULES_DIR), 0755)
-	os.MkdirAll(config.NFQ_RULES_DIR, 0755)
+	var serveErr error
+	select {
+	case serveErr = <-errc:
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	}
+	stop()
 
-	createDefaultFiles()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP server shutdown incomplete", "error", err)
+	}
+	dashboard.shutdown()
 
-	dashboard := NewDashboard()
+	if serveErr != nil {
+		fatal("HTTP server failed", "error", serveErr)
+	}
+}
 
+func (d *Dashboard) router() *mux.Router {
 	r := mux.NewRouter()
 
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", d.assets))
 
 	// HTML pages
-	r.HandleFunc("/", dashboard.mainHandler)
-	r.HandleFunc("/config", dashboard.configHandler)
-	r.HandleFunc("/logs", dashboard.logsHandler)
-	r.HandleFunc("/rules", dashboard.rulesHandler)
+	r.HandleFunc("/", d.mainHandler)
+	r.HandleFunc("/config", d.configHandler)
+	r.HandleFunc("/logs", d.logsHandler)
+	r.HandleFunc("/rules", d.rulesHandler)
 
 	// API
-	r.HandleFunc("/api/stats", dashboard.statsHandler)
-	r.HandleFunc("/api/logs", dashboard.getLogsHandler)
-	r.HandleFunc("/api/config", dashboard.configAPIHandler).Methods("GET", "POST")
-	r.HandleFunc("/api/rules/files", dashboard.ruleFilesHandler).Methods("GET")
-	r.HandleFunc("/api/rules/raw/{filename}", dashboard.rawRuleHandler).Methods("GET", "POST", "PUT", "DELETE")
-	r.HandleFunc("/api/rules", dashboard.rulesAPIHandler).Methods("GET", "POST")
-	r.HandleFunc("/api/rules/{id}", dashboard.ruleAPIHandler).Methods("GET", "PUT", "DELETE")
-	r.HandleFunc("/api/packet-stats", dashboard.packetStatsHandler)
-	r.HandleFunc("/api/restart/{service}", dashboard.restartServiceHandler).Methods("POST")
+	r.HandleFunc("/api/stats", d.statsHandler)
+	r.HandleFunc("/api/stats/history", d.statsHistoryHandler).Methods("GET")
+	r.HandleFunc("/api/interfaces", d.interfacesHandler).Methods("GET")
+	r.HandleFunc("/api/processes", d.processesHandler).Methods("GET")
+	r.HandleFunc("/api/alerts", d.alertsHandler).Methods("GET")
+	r.HandleFunc("/api/logs", d.getLogsHandler)
+	r.HandleFunc("/api/config", d.configAPIHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/settings", d.settingsAPIHandler).Methods("GET", "PUT")
+	r.HandleFunc("/api/rules/files", d.ruleFilesHandler).Methods("GET")
+	r.HandleFunc("/api/rules/raw/{filename}", d.rawRuleHandler).Methods("GET", "POST", "PUT", "DELETE")
+	r.HandleFunc("/api/rules", d.rulesAPIHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/rules/{id}", d.ruleAPIHandler).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/api/packet-stats", d.packetStatsHandler)
+	r.HandleFunc("/api/engine-stats", d.engineStatsHandler)
+	r.HandleFunc("/api/restart/{service}", d.restartServiceHandler).Methods("POST")
+	r.HandleFunc("/metrics", d.metricsHandler).Methods("GET")
 
 	// WebSocket
-	r.HandleFunc("/ws/stats", dashboard.wsStatsHandler)
-	r.HandleFunc("/ws/logs", dashboard.wsLogsHandler)
+	r.HandleFunc("/ws/stats", d.wsStatsHandler)
+	r.HandleFunc("/ws/logs", d.wsLogsHandler)
+	r.HandleFunc("/ws/alerts", d.wsAlertsHandler)
 
-	fmt.Println("Dashboard запущен на http://localhost:8080")
-	fmt.Println("Веб-интерфейс: http://localhost:8080/")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	return r
 }
 
-func createDefaultFiles() {
-	if _, err := os.Stat(config.NFQ_LOG_FILE); os.IsNotExist(err) {
-		os.WriteFile(config.NFQ_LOG_FILE, []byte(""), 0644)
+func (d *Dashboard) createDefaultFiles() {
+	cfg := getConfig()
+
+	if err := os.MkdirAll(d.path(cfg.NFQ_RULES_DIR), 0755); err != nil {
+		slog.Warn("Failed to create rules directory", "dir", d.path(cfg.NFQ_RULES_DIR), "error", err)
 	}
 
-	if _, err := os.Stat(config.NET_STATS_FILE); os.IsNotExist(err) {
-		defaultNetStats := map[string]interface{}{
-			"total":   1488,
-			"passed":  488,
-			"blocked": 1000,
-		}
-		data, _ := json.MarshalIndent(defaultNetStats, "", "    ")
-		os.WriteFile(config.NET_STATS_FILE, data, 0644)
+	if _, err := os.Stat(d.path(cfg.NFQ_LOG_FILE)); os.IsNotExist(err) {
+		writeDefaultFile(d.path(cfg.NFQ_LOG_FILE), []byte(""))
 	}
+}
 
-	if _, err := os.Stat(config.SYS_STATS_FILE); os.IsNotExist(err) {
-		defaultSysStats := map[string]interface{}{
-			"cpu":       0.0,
-			"ram":       0.0,
-			"disk":      0.0,
-			"timestamp": time.Now().Unix(),
-		}
-		data, _ := json.MarshalIndent(defaultSysStats, "", "    ")
-		os.WriteFile(config.SYS_STATS_FILE, data, 0644)
+func writeDefaultFile(path string, data []byte) {
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		slog.Warn("Failed to create default file", "file", path, "error", err)
+		return
 	}
+	slog.Debug("Created default file", "file", path)
 }
 
 func (d *Dashboard) statsHandler(w http.ResponseWriter, r *http.Request) {
-	stats, err := d.getSystemStats()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	stats := d.statsHub.Latest()
+	if stats == nil {
+		http.Error(w, "Статистика ещё не собрана", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -257,123 +221,174 @@ func (d *Dashboard) statsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
+// getSystemStats collects one sample. CPU usage is measured since the
+// previous sample, so it must only be called by the stats sampler.
 func (d *Dashboard) getSystemStats() (*SystemStats, error) {
-	cpuPercent, err := cpu.Percent(time.Second, false)
+	cpuPercent, err := d.stats.CPUPercent(0)
 	if err != nil {
 		return nil, err
 	}
 
-	memory, err := mem.VirtualMemory()
+	memory, err := d.stats.VirtualMemory()
 	if err != nil {
 		return nil, err
 	}
 
-	diskStat, err := disk.Usage("/")
+	diskStat, err := d.stats.DiskUsage("/")
 	if err != nil {
 		return nil, err
 	}
 
-	netStats, err := net.IOCounters(true)
+	counters, err := d.readInterfaceCounters()
 	if err != nil {
 		return nil, err
 	}
+	cfg := getConfig()
 
-	var networkStats NetworkStats
-	for _, stat := range netStats {
-		if stat.Name == config.Interface {
-			networkStats = NetworkStats{
-				BytesRecv: stat.BytesRecv,
-				BytesSent: stat.BytesSent,
-			}
-			break
-		}
-	}
-
-	var speedStats = SpeedStats{
-		Download: networkStats.BytesRecv - prev_netstats.BytesRecv,
-		Upload:   networkStats.BytesSent - prev_netstats.BytesSent,
+	interfaces := make(map[string]SpeedStats, len(counters))
+	d.netStatsMux.Lock()
+	for name, cur := range counters {
+		interfaces[name] = computeRates(d.prevNetStats[name], cur)
 	}
+	d.prevNetStats = counters
+	d.netStatsMux.Unlock()
 
 	stats := &SystemStats{
-		CPU:       cpuPercent[0],
-		RAM:       memory.UsedPercent,
-		RAMUsed:   memory.Used,
-		RAMTotal:  memory.Total,
-		Disk:      diskStat.UsedPercent,
-		DiskUsed:  diskStat.Used,
-		DiskTotal: diskStat.Total,
-		Speed:     speedStats,
-		Timestamp: time.Now().Unix(),
-	}
-
-	prev_netstats = networkStats
+		CPU:        cpuPercent,
+		RAM:        memory.UsedPercent,
+		RAMUsed:    memory.Used,
+		RAMTotal:   memory.Total,
+		Disk:       diskStat.UsedPercent,
+		DiskUsed:   diskStat.Used,
+		DiskTotal:  diskStat.Total,
+		Mounts:     d.collectMounts(cfg),
+		DiskIO:     d.collectDiskIO(),
+		Processes:  d.collectProcesses(),
+		Interface:  cfg.Interface,
+		Interfaces: interfaces,
+		Timestamp:  d.clock.Now().Unix(),
+	}
+	d.collectHardware(stats)
+
+	packets, source := d.readPacketStats()
+	stats.PacketSource = source
+	if packets != nil {
+		stats.Packets = packets
+		stats.PacketRates = d.packetRates.add(d.clock.Now(), packets)
+	}
+	stats.Engine, stats.EngineSource = d.readEngineStats()
 
 	return stats, nil
 }
 
-func (d *Dashboard) packetStatsHandler(w http.ResponseWriter, r *http.Request) {
-	statsFile := config.NET_STATS_FILE
-
-	if content, err := os.ReadFile(statsFile); err == nil {
-		var fileStats map[string]interface{}
-		if json.Unmarshal(content, &fileStats) == nil {
-			stats := PacketStats{}
-			if total, ok := fileStats["total"].(float64); ok {
-				stats.Total = uint64(total)
-			}
-			if passed, ok := fileStats["passed"].(float64); ok {
-				stats.Passed = uint64(passed)
-			}
-			if blocked, ok := fileStats["blocked"].(float64); ok {
-				stats.Blocked = uint64(blocked)
-			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(stats)
-			return
+func (d *Dashboard) readInterfaceCounters() (map[string]NetworkStats, error) {
+	netStats, err := d.stats.NetIOCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	now := d.clock.Now()
+	counters := make(map[string]NetworkStats, len(netStats))
+	for _, stat := range netStats {
+		counters[stat.Name] = NetworkStats{
+			BytesRecv:   stat.BytesRecv,
+			BytesSent:   stat.BytesSent,
+			PacketsRecv: stat.PacketsRecv,
+			PacketsSent: stat.PacketsSent,
+			ErrIn:       stat.Errin,
+			ErrOut:      stat.Errout,
+			DropIn:      stat.Dropin,
+			DropOut:     stat.Dropout,
+			Time:        now,
 		}
 	}
+	return counters, nil
+}
 
-	stats := PacketStats{
-		Total:   1,
-		Passed:  0,
-		Blocked: 0,
+func (d *Dashboard) resetNetStats() error {
+	counters, err := d.readInterfaceCounters()
+	if err != nil {
+		return err
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	d.netStatsMux.Lock()
+	d.prevNetStats = counters
+	d.netStatsMux.Unlock()
+	return nil
 }
 
-func (d *Dashboard) initLogWatcher() {
-	if info, err := os.Stat(config.NFQ_LOG_FILE); err == nil {
-		d.lastLogSize = info.Size()
+func (d *Dashboard) packetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	response := d.currentPacketStats()
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.PacketStats == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
+	json.NewEncoder(w).Encode(response)
+}
+func (d *Dashboard) initLogWatcher() {
+	d.resetLogWatcher(d.path(getConfig().NFQ_LOG_FILE))
 
+	d.wg.Add(1)
 	go d.watchLogFile()
 }
 
+func (d *Dashboard) resetLogWatcher(path string) {
+	d.logPath = path
+	d.lastLogSize = 0
+	info, err := os.Stat(path)
+	if err == nil {
+		d.lastLogSize = info.Size()
+	}
+	d.logWatch.present.Store(err == nil)
+	d.logWatch.offset.Store(d.lastLogSize)
+}
+
 func (d *Dashboard) watchLogFile() {
+	defer d.wg.Done()
+
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if info, err := os.Stat(config.NFQ_LOG_FILE); err == nil {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if path := d.path(getConfig().NFQ_LOG_FILE); path != d.logPath {
+			d.resetLogWatcher(path)
+			continue
+		}
+
+		info, err := os.Stat(d.logPath)
+		d.logWatch.present.Store(err == nil)
+		if err == nil {
 			currentSize := info.Size()
+			if currentSize < d.lastLogSize {
+				d.lastLogSize = 0
+				d.logWatch.truncations.Add(1)
+			}
 			if currentSize > d.lastLogSize {
 				d.readNewLogLines()
 				d.lastLogSize = currentSize
 			}
+			d.logWatch.offset.Store(d.lastLogSize)
 		}
 	}
 }
 
 func (d *Dashboard) readNewLogLines() {
-	file, err := os.Open(config.NFQ_LOG_FILE)
+	file, err := os.Open(d.logPath)
 	if err != nil {
+		slog.Warn("Failed to open NFQ log", "file", d.logPath, "error", err)
 		return
 	}
 	defer file.Close()
 
 	if _, err := file.Seek(d.lastLogSize, 0); err != nil {
+		slog.Warn("Failed to seek NFQ log", "file", d.logPath, "offset", d.lastLogSize, "error", err)
 		return
 	}
 
@@ -386,12 +401,42 @@ func (d *Dashboard) readNewLogLines() {
 			newLines = append(newLines, line)
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		slog.Warn("Failed to read NFQ log", "file", d.logPath, "error", err)
+	}
 
 	if len(newLines) > 0 {
+		d.logWatch.lines.Add(uint64(len(newLines)))
 		d.broadcastLogLines(newLines)
 	}
 }
 
+// shutdown waits for the background goroutines to stop after d.ctx is
+// cancelled and closes the remaining log clients with a close frame.
+func (d *Dashboard) shutdown() {
+	d.wg.Wait()
+
+	if d.store != nil {
+		if err := d.store.Close(); err != nil {
+			slog.Warn("Failed to close metrics store", "error", err)
+		}
+	}
+
+	d.logClientsMux.Lock()
+	defer d.logClientsMux.Unlock()
+
+	for client := range d.logClients {
+		closeWebSocket(client)
+		delete(d.logClients, client)
+	}
+}
+
+func closeWebSocket(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	conn.Close()
+}
+
 func (d *Dashboard) broadcastLogLines(lines []string) {
 	d.logClientsMux.RLock()
 	defer d.logClientsMux.RUnlock()
@@ -399,11 +444,12 @@ func (d *Dashboard) broadcastLogLines(lines []string) {
 	message := map[string]interface{}{
 		"type":      "logs",
 		"lines":     lines,
-		"timestamp": time.Now().Unix(),
+		"timestamp": d.clock.Now().Unix(),
 	}
 
 	for client := range d.logClients {
 		if err := client.WriteJSON(message); err != nil {
+			slog.Debug("Log client disconnected", "error", err)
 			delete(d.logClients, client)
 			client.Close()
 		}
@@ -413,7 +459,7 @@ func (d *Dashboard) broadcastLogLines(lines []string) {
 func (d *Dashboard) wsLogsHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := d.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.Warn("WebSocket upgrade failed", "path", r.URL.Path, "error", err)
 		return
 	}
 
@@ -438,8 +484,10 @@ func (d *Dashboard) wsLogsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (d *Dashboard) sendRecentLogs(conn *websocket.Conn) {
-	content, err := os.ReadFile(config.NFQ_LOG_FILE)
+	logFile := d.path(getConfig().NFQ_LOG_FILE)
+	content, err := os.ReadFile(logFile)
 	if err != nil {
+		slog.Warn("Failed to read NFQ log", "file", logFile, "error", err)
 		return
 	}
 
@@ -460,7 +508,7 @@ func (d *Dashboard) sendRecentLogs(conn *websocket.Conn) {
 		message := map[string]interface{}{
 			"type":      "initial_logs",
 			"lines":     recentLines,
-			"timestamp": time.Now().Unix(),
+			"timestamp": d.clock.Now().Unix(),
 		}
 
 		conn.WriteJSON(message)
@@ -471,18 +519,13 @@ func (d *Dashboard) restartServiceHandler(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	service := vars["service"]
 
-	var cmd *exec.Cmd
-	switch service {
-	case "web":
-		cmd = exec.Command("sudo", "systemctl", "restart", "gex-web")
-	case "nfq":
-		cmd = exec.Command("sudo", "systemctl", "restart", "ips")
-	default:
+	unit, ok := serviceUnit(service)
+	if !ok {
 		http.Error(w, "Неизвестная служба", http.StatusBadRequest)
 		return
 	}
 
-	err := cmd.Run()
+	err := d.commands.Run(r.Context(), "sudo", "systemctl", "restart", unit)
 	response := map[string]interface{}{
 		"success": err == nil,
 		"service": service,
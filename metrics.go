@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// logWatchState mirrors the log watcher for /metrics; the watcher itself
+// keeps using logPath/lastLogSize from its own goroutine.
+type logWatchState struct {
+	present     atomic.Bool
+	offset      atomic.Int64
+	lines       atomic.Uint64
+	truncations atomic.Uint64
+}
+
+// metricsWriter renders the Prometheus text exposition format.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (m *metricsWriter) family(name, kind, help string) {
+	m.buf.WriteString("# HELP " + name + " " + help + "\n")
+	m.buf.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// sample writes one value; labels are given as name, value pairs.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteByte(',')
+			}
+			m.buf.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+func (m *metricsWriter) gauge(name, help string, value float64, labels ...string) {
+	m.family(name, "gauge", help)
+	m.sample(name, value, labels...)
+}
+
+func (m *metricsWriter) counter(name, help string, value float64, labels ...string) {
+	m.family(name, "counter", help)
+	m.sample(name, value, labels...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (d *Dashboard) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m metricsWriter
+	cfg := getConfig()
+
+	if stats := d.statsHub.Latest(); stats != nil {
+		m.gauge("gex_cpu_usage_percent", "CPU usage in percent.", stats.CPU)
+		m.gauge("gex_memory_total_bytes", "Total RAM.", float64(stats.RAMTotal))
+		m.gauge("gex_memory_used_bytes", "Used RAM.", float64(stats.RAMUsed))
+		mounts := []struct {
+			name, help string
+			value      func(m *MountStats) float64
+		}{
+			{"gex_disk_total_bytes", "Size of the filesystem.", func(m *MountStats) float64 { return float64(m.Total) }},
+			{"gex_disk_used_bytes", "Used space on the filesystem.", func(m *MountStats) float64 { return float64(m.Used) }},
+			{"gex_disk_inodes_total", "Inodes on the filesystem.", func(m *MountStats) float64 { return float64(m.InodesTotal) }},
+			{"gex_disk_inodes_used", "Used inodes on the filesystem.", func(m *MountStats) float64 { return float64(m.InodesUsed) }},
+			{"gex_disk_warning", "Whether space or inode usage passed diskWarnPercent.", func(m *MountStats) float64 { return boolValue(m.Warning) }},
+		}
+		for _, f := range mounts {
+			m.family(f.name, "gauge", f.help)
+			for i := range stats.Mounts {
+				m.sample(f.name, f.value(&stats.Mounts[i]), "mountpoint", stats.Mounts[i].Path)
+			}
+		}
+		processes := []struct {
+			name, kind, help string
+			value            func(p *ProcessStats) float64
+		}{
+			{"gex_process_up", "gauge", "Whether the service's main process is running.", func(p *ProcessStats) float64 { return boolValue(p.PID > 0 && p.Error == "") }},
+			{"gex_process_cpu_percent", "gauge", "CPU usage of the main process.", func(p *ProcessStats) float64 { return p.CPU }},
+			{"gex_process_resident_memory_bytes", "gauge", "Resident memory of the main process.", func(p *ProcessStats) float64 { return float64(p.RSS) }},
+			{"gex_process_open_fds", "gauge", "Open file descriptors, -1 if unreadable.", func(p *ProcessStats) float64 { return float64(p.FDs) }},
+			{"gex_process_threads", "gauge", "Threads of the main process.", func(p *ProcessStats) float64 { return float64(p.Threads) }},
+			{"gex_process_start_time_seconds", "gauge", "Start time of the main process.", func(p *ProcessStats) float64 { return float64(p.StartTime) }},
+			{"gex_process_restarts_total", "counter", "Automatic restarts of the unit counted by systemd.", func(p *ProcessStats) float64 { return float64(p.Restarts) }},
+		}
+		for _, f := range processes {
+			m.family(f.name, f.kind, f.help)
+			for i := range stats.Processes {
+				m.sample(f.name, f.value(&stats.Processes[i]), "service", stats.Processes[i].Service, "unit", stats.Processes[i].Unit)
+			}
+		}
+		m.gauge("gex_stats_last_sample_timestamp_seconds", "Time of the last stats sample.", float64(stats.Timestamp))
+	}
+
+	if counters, err := d.stats.NetIOCounters(); err != nil {
+		slog.Warn("Failed to read interface counters", "error", err)
+	} else {
+		sort.Slice(counters, func(i, j int) bool { return counters[i].Name < counters[j].Name })
+		families := []struct {
+			name, help string
+			value      func(i int) uint64
+		}{
+			{"gex_network_receive_bytes_total", "Bytes received by the interface.", func(i int) uint64 { return counters[i].BytesRecv }},
+			{"gex_network_transmit_bytes_total", "Bytes sent by the interface.", func(i int) uint64 { return counters[i].BytesSent }},
+			{"gex_network_receive_packets_total", "Packets received by the interface.", func(i int) uint64 { return counters[i].PacketsRecv }},
+			{"gex_network_transmit_packets_total", "Packets sent by the interface.", func(i int) uint64 { return counters[i].PacketsSent }},
+			{"gex_network_receive_errors_total", "Receive errors on the interface.", func(i int) uint64 { return counters[i].Errin }},
+			{"gex_network_transmit_errors_total", "Transmit errors on the interface.", func(i int) uint64 { return counters[i].Errout }},
+			{"gex_network_receive_drop_total", "Inbound packets dropped on the interface.", func(i int) uint64 { return counters[i].Dropin }},
+			{"gex_network_transmit_drop_total", "Outbound packets dropped on the interface.", func(i int) uint64 { return counters[i].Dropout }},
+		}
+		for _, f := range families {
+			m.family(f.name, "counter", f.help)
+			for i := range counters {
+				m.sample(f.name, float64(f.value(i)), "interface", counters[i].Name)
+			}
+		}
+	}
+
+	packets, source := d.readPacketStats()
+	m.gauge("gex_nfq_stats_up", "Whether the NFQ stats file could be read.", boolValue(packets != nil))
+	m.gauge("gex_nfq_stats_stale", "Whether the NFQ stats file has not been updated within statsStaleAfter.", boolValue(source.Stale))
+	if source.ModTime > 0 {
+		m.gauge("gex_nfq_stats_mtime_seconds", "Modification time of the NFQ stats file.", float64(source.ModTime))
+	}
+	if packets != nil {
+		m.counter("gex_nfq_packets_total", "Packets seen by NFQ.", float64(packets.Total))
+		m.counter("gex_nfq_packets_passed_total", "Packets passed by NFQ.", float64(packets.Passed))
+		m.counter("gex_nfq_packets_blocked_total", "Packets blocked by NFQ.", float64(packets.Blocked))
+		if packets.Bytes != nil {
+			m.counter("gex_nfq_bytes_total", "Bytes seen by NFQ.", float64(packets.Bytes.Total))
+			m.counter("gex_nfq_bytes_passed_total", "Bytes passed by NFQ.", float64(packets.Bytes.Passed))
+			m.counter("gex_nfq_bytes_blocked_total", "Bytes blocked by NFQ.", float64(packets.Bytes.Blocked))
+		}
+		breakdowns := []struct {
+			name, help, label string
+			rows              map[string]PacketCounters
+			value             func(c PacketCounters) uint64
+		}{
+			{"gex_nfq_protocol_packets_total", "Packets seen by NFQ per protocol.", "protocol", packets.Protocols, func(c PacketCounters) uint64 { return c.Packets }},
+			{"gex_nfq_protocol_bytes_total", "Bytes seen by NFQ per protocol.", "protocol", packets.Protocols, func(c PacketCounters) uint64 { return c.Bytes }},
+			{"gex_nfq_protocol_blocked_total", "Packets blocked by NFQ per protocol.", "protocol", packets.Protocols, func(c PacketCounters) uint64 { return c.Blocked }},
+			{"gex_nfq_queue_packets_total", "Packets seen per NFQ queue.", "queue", packets.Queues, func(c PacketCounters) uint64 { return c.Packets }},
+			{"gex_nfq_queue_blocked_total", "Packets blocked per NFQ queue.", "queue", packets.Queues, func(c PacketCounters) uint64 { return c.Blocked }},
+			{"gex_nfq_queue_dropped_total", "Packets dropped by a full NFQ queue.", "queue", packets.Queues, func(c PacketCounters) uint64 { return c.Dropped }},
+		}
+		for _, f := range breakdowns {
+			if len(f.rows) == 0 {
+				continue
+			}
+			m.family(f.name, "counter", f.help)
+			for _, key := range sortedKeys(f.rows) {
+				m.sample(f.name, float64(f.value(f.rows[key])), f.label, key)
+			}
+		}
+		if len(packets.Rules) > 0 {
+			m.family("gex_nfq_rule_hits_total", "counter", "Packets matched by an NFQ rule.")
+			for _, id := range sortedKeys(packets.Rules) {
+				m.sample("gex_nfq_rule_hits_total", float64(packets.Rules[id].Hits), "rule", id)
+			}
+		}
+	}
+
+	engine, engineSource := d.readEngineStats()
+	m.gauge("gex_engine_stats_up", "Whether the NFQ engine stats file could be read.", boolValue(engine != nil))
+	m.gauge("gex_engine_stats_stale", "Whether the NFQ engine stats file has not been updated within statsStaleAfter.", boolValue(engineSource.Stale))
+	if engineSource.ModTime > 0 {
+		m.gauge("gex_engine_stats_mtime_seconds", "Modification time of the NFQ engine stats file.", float64(engineSource.ModTime))
+	}
+	if engine != nil {
+		m.gauge("gex_engine_cpu_percent", "CPU usage reported by the NFQ engine.", engine.CPU)
+		m.gauge("gex_engine_memory_bytes", "Memory usage reported by the NFQ engine.", float64(engine.Memory))
+		queues := []struct {
+			name, help string
+			value      func(q EngineQueueStats) float64
+		}{
+			{"gex_engine_queue_depth", "Packets waiting in the NFQ queue.", func(q EngineQueueStats) float64 { return float64(q.Depth) }},
+			{"gex_engine_queue_latency_avg_seconds", "Average time until a verdict.", func(q EngineQueueStats) float64 { return q.LatencyAvgMs / 1000 }},
+			{"gex_engine_queue_latency_p99_seconds", "99th percentile of the time until a verdict.", func(q EngineQueueStats) float64 { return q.LatencyP99Ms / 1000 }},
+		}
+		if len(engine.Queues) > 0 {
+			for _, f := range queues {
+				m.family(f.name, "gauge", f.help)
+				for _, queue := range sortedKeys(engine.Queues) {
+					m.sample(f.name, f.value(engine.Queues[queue]), "queue", queue)
+				}
+			}
+		}
+		if len(engine.Workers) > 0 {
+			m.family("gex_engine_worker_utilization_percent", "gauge", "Busy time of an NFQ engine worker.")
+			for _, worker := range sortedKeys(engine.Workers) {
+				m.sample("gex_engine_worker_utilization_percent", engine.Workers[worker].Utilization, "worker", worker)
+			}
+		}
+	}
+
+	m.gauge("gex_log_watcher_file_present", "Whether the NFQ log file exists.", boolValue(d.logWatch.present.Load()), "path", d.path(cfg.NFQ_LOG_FILE))
+	m.gauge("gex_log_watcher_offset_bytes", "Position up to which the NFQ log has been read.", float64(d.logWatch.offset.Load()))
+	m.counter("gex_log_watcher_lines_total", "NFQ log lines broadcast to clients.", float64(d.logWatch.lines.Load()))
+	m.counter("gex_log_watcher_truncations_total", "Times the NFQ log was truncated or rotated.", float64(d.logWatch.truncations.Load()))
+
+	alerts, _, _ := d.alerts.snapshot()
+	m.family("gex_alert_firing", "gauge", "Whether an alert rule is firing.")
+	for _, a := range alerts {
+		m.sample("gex_alert_firing", boolValue(a.State == alertFiring), "rule", a.Name, "severity", a.Severity)
+	}
+
+	d.logClientsMux.RLock()
+	logClients := len(d.logClients)
+	d.logClientsMux.RUnlock()
+	m.family("gex_websocket_clients", "gauge", "Connected WebSocket clients.")
+	m.sample("gex_websocket_clients", float64(logClients), "stream", "logs")
+	m.sample("gex_websocket_clients", float64(d.statsHub.Subscribers()), "stream", "stats")
+	m.sample("gex_websocket_clients", float64(d.alerts.Subscribers()), "stream", "alerts")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.buf.Bytes())
+}
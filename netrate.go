@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Upper bounds on what a 32-bit counter wrap may imply. The boards have at
+// most gigabit Ethernet, so a faster "wrap" is really a reset of a 64-bit
+// counter.
+const (
+	maxLinkBytesRate   = 125e6
+	maxLinkPacketsRate = 1.5e6
+)
+
+// counterDelta returns how much a cumulative counter grew between two
+// samples elapsed apart. A drop is unwrapped as a 32-bit wrap only when the
+// resulting rate stays under maxRate; any other drop is a reset (interface
+// flap, driver reload) and reported with ok=false.
+func counterDelta(prev, cur uint64, elapsed time.Duration, maxRate float64) (delta uint64, ok bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	if prev <= math.MaxUint32 {
+		delta = math.MaxUint32 - prev + cur + 1
+		if float64(delta) <= maxRate*elapsed.Seconds() {
+			return delta, true
+		}
+	}
+	return 0, false
+}
+
+// computeRates turns two timestamped interface samples into per-second
+// rates. After a counter reset all rates are zero for one sample.
+func computeRates(prev, cur NetworkStats) SpeedStats {
+	rates := SpeedStats{
+		ErrorsIn:  cur.ErrIn,
+		ErrorsOut: cur.ErrOut,
+		DropsIn:   cur.DropIn,
+		DropsOut:  cur.DropOut,
+	}
+
+	elapsed := cur.Time.Sub(prev.Time)
+	if prev.Time.IsZero() || elapsed <= 0 {
+		return rates
+	}
+
+	pairs := []struct {
+		prev, cur uint64
+		rate      *float64
+		max       float64
+	}{
+		{prev.BytesRecv, cur.BytesRecv, &rates.Download, maxLinkBytesRate},
+		{prev.BytesSent, cur.BytesSent, &rates.Upload, maxLinkBytesRate},
+		{prev.PacketsRecv, cur.PacketsRecv, &rates.PacketsIn, maxLinkPacketsRate},
+		{prev.PacketsSent, cur.PacketsSent, &rates.PacketsOut, maxLinkPacketsRate},
+		{prev.ErrIn, cur.ErrIn, &rates.ErrorsInRate, maxLinkPacketsRate},
+		{prev.ErrOut, cur.ErrOut, &rates.ErrorsOutRate, maxLinkPacketsRate},
+		{prev.DropIn, cur.DropIn, &rates.DropsInRate, maxLinkPacketsRate},
+		{prev.DropOut, cur.DropOut, &rates.DropsOutRate, maxLinkPacketsRate},
+	}
+
+	deltas := make([]uint64, len(pairs))
+	for i, p := range pairs {
+		delta, ok := counterDelta(p.prev, p.cur, elapsed, p.max)
+		if !ok {
+			rates.Reset = true
+			return rates
+		}
+		deltas[i] = delta
+	}
+
+	seconds := elapsed.Seconds()
+	for i, p := range pairs {
+		*p.rate = float64(deltas[i]) / seconds
+	}
+	rates.Interval = elapsed.Round(time.Millisecond).Seconds()
+	return rates
+}
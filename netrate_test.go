@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		delta     uint64
+		ok        bool
+	}{
+		{"growth", 100, 250, 150, true},
+		{"unchanged", 100, 100, 0, true},
+		{"32-bit wrap", math.MaxUint32 - 9, 20, 30, true},
+		{"32-bit wrap at line rate", math.MaxUint32 - 99999999, 100000000, 200000000, true},
+		{"reset", 5000000, 1200, 0, false},
+		{"64-bit counter drop", math.MaxUint32 + 100, 50, 0, false},
+		// Unwrapping would mean 1.3 GB in 2 s.
+		{"64-bit counter reset from 3e9", 3000000000, 100, 0, false},
+	}
+	for _, tt := range tests {
+		delta, ok := counterDelta(tt.prev, tt.cur, 2*time.Second, maxLinkBytesRate)
+		if delta != tt.delta || ok != tt.ok {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tt.name, delta, ok, tt.delta, tt.ok)
+		}
+	}
+}
+
+func TestComputeRates(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	prev := NetworkStats{BytesRecv: 1000, BytesSent: 500, PacketsRecv: 10, DropIn: 1, Time: start}
+
+	cur := NetworkStats{BytesRecv: 6000, BytesSent: 1500, PacketsRecv: 60, DropIn: 3, Time: start.Add(5 * time.Second)}
+	rates := computeRates(prev, cur)
+	if rates.Download != 1000 || rates.Upload != 200 || rates.PacketsIn != 10 || rates.DropsInRate != 0.4 || rates.DropsIn != 3 || rates.Reset {
+		t.Errorf("rates = %+v", rates)
+	}
+
+	reset := NetworkStats{BytesRecv: 10, BytesSent: 10, DropIn: 0, Time: start.Add(5 * time.Second)}
+	if rates := computeRates(prev, reset); !rates.Reset || rates.Download != 0 {
+		t.Errorf("after reset rates = %+v", rates)
+	}
+
+	// An interface flap while a 64-bit byte counter is between 2 and 4 GB.
+	big := NetworkStats{BytesRecv: 3000000000, BytesSent: 500, PacketsRecv: 10, Time: start}
+	flapped := NetworkStats{BytesRecv: 100, BytesSent: 600, PacketsRecv: 12, Time: start.Add(2 * time.Second)}
+	if rates := computeRates(big, flapped); !rates.Reset || rates.Download != 0 {
+		t.Errorf("64-bit reset from 3e9: rates = %+v", rates)
+	}
+
+	if rates := computeRates(NetworkStats{}, cur); rates.Download != 0 || rates.Reset {
+		t.Errorf("first sample rates = %+v", rates)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"time"
+)
+
+// packetRateWindows are the averaging windows reported for NFQ counters.
+var packetRateWindows = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+// PacketRates are packets per second over Window seconds. BlockRatio is
+// blocked/(passed+blocked) over the same window, 0 when nothing was seen.
+type PacketRates struct {
+	Total      float64 `json:"total"`
+	Passed     float64 `json:"passed"`
+	Blocked    float64 `json:"blocked"`
+	BlockRatio float64 `json:"blockRatio"`
+	Window     float64 `json:"window"`
+}
+
+// PacketRateStats holds the rate between the last two samples and the
+// moving windows. Resets counts engine restarts seen as counter drops.
+type PacketRateStats struct {
+	Current PacketRates            `json:"current"`
+	Windows map[string]PacketRates `json:"windows"`
+	Resets  uint64                 `json:"resets"`
+}
+
+// packetCounters are the NFQ counters made monotonic across resets.
+type packetCounters struct {
+	time                   time.Time
+	total, passed, blocked float64
+}
+
+// packetRateTracker keeps 15 minutes of counter samples. It is only used
+// by the stats sampler.
+type packetRateTracker struct {
+	last    *PacketStats
+	adj     packetCounters
+	samples []packetCounters
+	resets  uint64
+}
+
+func (t *packetRateTracker) add(now time.Time, p *PacketStats) *PacketRateStats {
+	if t.last != nil {
+		// The engine's counters are 64-bit JSON numbers and never wrap, so
+		// any drop is a restart. After it the engine counts from zero
+		// again, so the new values are the increase since the restart.
+		deltas := [3]uint64{}
+		reset := false
+		for i, pair := range [3][2]uint64{
+			{t.last.Total, p.Total},
+			{t.last.Passed, p.Passed},
+			{t.last.Blocked, p.Blocked},
+		} {
+			if pair[1] < pair[0] {
+				reset = true
+				continue
+			}
+			deltas[i] = pair[1] - pair[0]
+		}
+		if reset {
+			t.resets++
+			deltas = [3]uint64{p.Total, p.Passed, p.Blocked}
+		}
+		t.adj.total += float64(deltas[0])
+		t.adj.passed += float64(deltas[1])
+		t.adj.blocked += float64(deltas[2])
+	}
+	t.last = &PacketStats{Total: p.Total, Passed: p.Passed, Blocked: p.Blocked}
+	t.adj.time = now
+
+	t.samples = append(t.samples, t.adj)
+	horizon := now.Add(-packetRateWindows[len(packetRateWindows)-1].window)
+	drop := 0
+	for drop < len(t.samples)-1 && t.samples[drop+1].time.Before(horizon) {
+		drop++
+	}
+	t.samples = t.samples[drop:]
+
+	stats := &PacketRateStats{Windows: make(map[string]PacketRates), Resets: t.resets}
+	if n := len(t.samples); n >= 2 {
+		stats.Current = packetRatesBetween(t.samples[n-2], t.samples[n-1])
+	}
+	for _, w := range packetRateWindows {
+		stats.Windows[w.name] = packetRatesBetween(t.windowStart(now.Add(-w.window)), t.adj)
+	}
+	return stats
+}
+
+// windowStart returns the newest sample at or before start, or the oldest
+// one when history does not reach that far back yet.
+func (t *packetRateTracker) windowStart(start time.Time) packetCounters {
+	first := t.samples[0]
+	for _, s := range t.samples {
+		if s.time.After(start) {
+			break
+		}
+		first = s
+	}
+	return first
+}
+
+func packetRatesBetween(from, to packetCounters) PacketRates {
+	elapsed := to.time.Sub(from.time).Seconds()
+	if elapsed <= 0 {
+		return PacketRates{}
+	}
+
+	rates := PacketRates{
+		Total:   (to.total - from.total) / elapsed,
+		Passed:  (to.passed - from.passed) / elapsed,
+		Blocked: (to.blocked - from.blocked) / elapsed,
+		Window:  elapsed,
+	}
+	if seen := rates.Passed + rates.Blocked; seen > 0 {
+		rates.BlockRatio = rates.Blocked / seen
+	}
+	return rates
+}
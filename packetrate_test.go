@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPacketRateTracker(t *testing.T) {
+	var tracker packetRateTracker
+	start := time.Unix(1700000000, 0)
+
+	// 20 minutes of samples every 30 s: 2 passed and 1 blocked per second.
+	var stats *PacketRateStats
+	p := PacketStats{}
+	for i := 0; i <= 40; i++ {
+		stats = tracker.add(start.Add(time.Duration(i)*30*time.Second), &PacketStats{Total: p.Total, Passed: p.Passed, Blocked: p.Blocked})
+		p = PacketStats{Total: p.Total + 90, Passed: p.Passed + 60, Blocked: p.Blocked + 30}
+	}
+
+	want := PacketRates{Total: 3, Passed: 2, Blocked: 1, BlockRatio: 1.0 / 3, Window: 30}
+	if stats.Current != want {
+		t.Errorf("current = %+v", stats.Current)
+	}
+	for name, window := range map[string]float64{"1m": 60, "5m": 300, "15m": 900} {
+		got := stats.Windows[name]
+		if got.Window != window || got.Blocked != 1 || math.Abs(got.BlockRatio-1.0/3) > 1e-9 {
+			t.Errorf("%s = %+v", name, got)
+		}
+	}
+	if len(tracker.samples) > 32 {
+		t.Errorf("kept %d samples for a 15m window", len(tracker.samples))
+	}
+
+	// The engine restarts and counts from zero: 15 blocked in the 30 s
+	// since the last sample.
+	stats = tracker.add(start.Add(41*30*time.Second), &PacketStats{Total: 15, Blocked: 15})
+	if stats.Resets != 1 || stats.Current.Blocked != 0.5 || stats.Current.Passed != 0 || stats.Current.BlockRatio != 1 {
+		t.Errorf("after reset: %+v", stats)
+	}
+	if w := stats.Windows["1m"]; w.Blocked != 0.75 || w.Passed != 1 {
+		t.Errorf("1m after reset = %+v", w)
+	}
+
+	// A restart after 3 billion packets is a reset too, not a 32-bit wrap.
+	var big packetRateTracker
+	big.add(start, &PacketStats{Total: 3000000000, Blocked: 3000000000})
+	stats = big.add(start.Add(10*time.Second), &PacketStats{Total: 100, Blocked: 100})
+	if stats.Resets != 1 || stats.Current.Blocked != 10 {
+		t.Errorf("restart from 3e9: %+v", stats)
+	}
+}
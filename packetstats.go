@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PacketCounters is one row of the per-protocol or per-queue breakdown.
+type PacketCounters struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+	Passed  uint64 `json:"passed"`
+	Blocked uint64 `json:"blocked"`
+	Dropped uint64 `json:"dropped,omitempty"`
+
+	// Extra keeps row fields the schema does not describe.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (c *PacketCounters) UnmarshalJSON(data []byte) error {
+	type known PacketCounters
+	var k known
+	extra, err := unmarshalWithExtra(data, &k)
+	*c = PacketCounters(k)
+	c.Extra = extra
+	return err
+}
+
+func (c PacketCounters) MarshalJSON() ([]byte, error) {
+	type known PacketCounters
+	return jsonObject(known(c), c.Extra)
+}
+
+type ByteCounters struct {
+	Total   uint64 `json:"total"`
+	Passed  uint64 `json:"passed"`
+	Blocked uint64 `json:"blocked"`
+}
+
+// RuleCounters are keyed by rule ID, the same IDs as /api/rules.
+type RuleCounters struct {
+	Hits    uint64 `json:"hits"`
+	Bytes   uint64 `json:"bytes"`
+	LastHit int64  `json:"lastHit,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (c *RuleCounters) UnmarshalJSON(data []byte) error {
+	type known RuleCounters
+	var k known
+	extra, err := unmarshalWithExtra(data, &k)
+	*c = RuleCounters(k)
+	c.Extra = extra
+	return err
+}
+
+func (c RuleCounters) MarshalJSON() ([]byte, error) {
+	type known RuleCounters
+	return jsonObject(known(c), c.Extra)
+}
+
+type PacketStats struct {
+	Version   int                       `json:"version"`
+	Total     uint64                    `json:"total"`
+	Passed    uint64                    `json:"passed"`
+	Blocked   uint64                    `json:"blocked"`
+	Bytes     *ByteCounters             `json:"bytes,omitempty"`
+	Protocols map[string]PacketCounters `json:"protocols,omitempty"`
+	Queues    map[string]PacketCounters `json:"queues,omitempty"`
+	Rules     map[string]RuleCounters   `json:"rules,omitempty"`
+
+	// Extra keeps top-level fields the schema does not describe so that
+	// new engine metrics reach the API unchanged.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// packetStatsVersion is the newest net_stats_file schema this dashboard
+// understands.
+const packetStatsVersion = 1
+
+// parsePacketStats decodes net_stats_file. total, passed and blocked are
+// required; breakdowns are optional but must match the schema if present.
+func parsePacketStats(content []byte) (*PacketStats, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+
+	// Files without a version are the original flat total/passed/blocked
+	// format, which is version 1 of the schema.
+	stats := &PacketStats{Version: 1}
+	if raw, ok := fields["version"]; ok {
+		if err := json.Unmarshal(raw, &stats.Version); err != nil || stats.Version < 1 {
+			return nil, fmt.Errorf("field %q must be a positive integer", "version")
+		}
+		if stats.Version > packetStatsVersion {
+			return nil, fmt.Errorf("stats version %d is newer than supported version %d", stats.Version, packetStatsVersion)
+		}
+		delete(fields, "version")
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"total", &stats.Total},
+		{"passed", &stats.Passed},
+		{"blocked", &stats.Blocked},
+	} {
+		var v *float64
+		if err := json.Unmarshal(fields[field.name], &v); err != nil || v == nil || *v < 0 {
+			return nil, missingField(field.name)
+		}
+		*field.value = uint64(*v)
+		delete(fields, field.name)
+	}
+
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"bytes", &stats.Bytes},
+		{"protocols", &stats.Protocols},
+		{"queues", &stats.Queues},
+		{"rules", &stats.Rules},
+	} {
+		raw, ok := fields[field.name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, field.value); err != nil {
+			return nil, fmt.Errorf("field %q: %v", field.name, err)
+		}
+		delete(fields, field.name)
+	}
+
+	if len(fields) > 0 {
+		stats.Extra = fields
+	}
+	return stats, nil
+}
+
+func (p PacketStats) MarshalJSON() ([]byte, error) {
+	type known PacketStats
+	return jsonObject(known(p), p.Extra)
+}
+
+// readPacketStats returns the NFQ counters and the freshness of
+// net_stats_file; the counters are nil when the file is unusable.
+func (d *Dashboard) readPacketStats() (*PacketStats, *SourceStatus) {
+	content, source := d.readStatsSource(getConfig().NET_STATS_FILE)
+	if content == nil {
+		return nil, source
+	}
+
+	stats, err := parsePacketStats(content)
+	if err != nil {
+		source.unavailable(err)
+		return nil, source
+	}
+	return stats, source
+}
+
+// packetStatsResponse adds the freshness of the file and the sampler's
+// rates to the live counters.
+type packetStatsResponse struct {
+	*PacketStats
+	Rates *PacketRateStats `json:"rates,omitempty"`
+	*SourceStatus
+}
+
+func (d *Dashboard) currentPacketStats() packetStatsResponse {
+	stats, source := d.readPacketStats()
+	response := packetStatsResponse{PacketStats: stats, SourceStatus: source}
+	if stats != nil {
+		if latest := d.statsHub.Latest(); latest != nil {
+			response.Rates = latest.PacketRates
+		}
+	}
+	return response
+}
+
+func (r packetStatsResponse) MarshalJSON() ([]byte, error) {
+	type envelope struct {
+		Rates *PacketRateStats `json:"rates,omitempty"`
+		*SourceStatus
+	}
+	return jsonObject(envelope{r.Rates, r.SourceStatus}, r.PacketStats)
+}
+
+// jsonObject merges the fields of values that marshal to JSON objects.
+// When names collide the earlier value wins, so pass-through fields can
+// never shadow the dashboard's own.
+func jsonObject(values ...interface{}) ([]byte, error) {
+	merged := map[string]json.RawMessage{}
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		for name, value := range fields {
+			if _, ok := merged[name]; !ok {
+				merged[name] = value
+			}
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// unmarshalWithExtra decodes data into the struct known points to and
+// returns the object fields that struct has no json tag for, or nil.
+func unmarshalWithExtra(data []byte, known interface{}) (map[string]json.RawMessage, error) {
+	if err := json.Unmarshal(data, known); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(known).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		delete(fields, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	packetWatchInterval = 250 * time.Millisecond
+	// packetPushInterval limits how often packet_stats messages go out, so
+	// an engine rewriting net_stats_file in a tight loop cannot flood the
+	// clients; the last change in a burst is always delivered.
+	packetPushInterval = time.Second
+)
+
+// packetStatsMessage is pushed over /ws/stats next to the plain
+// SystemStats snapshots, which carry no type field.
+type packetStatsMessage struct {
+	Type  string              `json:"type"`
+	Stats packetStatsResponse `json:"packetStats"`
+}
+
+// packetFileStamp is what the watcher compares between polls. The stale
+// flag is included so clients learn when the engine stops writing.
+type packetFileStamp struct {
+	path    string
+	present bool
+	size    int64
+	modTime int64
+	stale   bool
+}
+
+func (d *Dashboard) packetFileStamp() packetFileStamp {
+	stamp := packetFileStamp{path: d.path(getConfig().NET_STATS_FILE)}
+	info, err := os.Stat(stamp.path)
+	if err != nil {
+		return stamp
+	}
+	stamp.present = true
+	stamp.size = info.Size()
+	stamp.modTime = info.ModTime().UnixNano()
+	stamp.stale = d.clock.Now().Sub(info.ModTime()) > statsStaleAfter(getConfig())
+	return stamp
+}
+
+func (d *Dashboard) publishPacketStats() {
+	d.packetHub.publish(&packetStatsMessage{Type: "packet_stats", Stats: d.currentPacketStats()})
+}
+
+// watchPacketStats polls net_stats_file and pushes its content to the
+// stats clients whenever the engine rewrites it or it goes stale.
+func (d *Dashboard) watchPacketStats() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(packetWatchInterval)
+	defer ticker.Stop()
+
+	last := d.packetFileStamp()
+	pending := false
+	var lastPush time.Time
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if stamp := d.packetFileStamp(); stamp != last {
+			last = stamp
+			pending = true
+		}
+		if now := d.clock.Now(); pending && now.Sub(lastPush) >= packetPushInterval {
+			pending = false
+			lastPush = now
+			d.publishPacketStats()
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// services are the systemd units the dashboard controls, by the name used
+// in the API.
+var services = []struct {
+	name, unit string
+}{
+	{"web", "gex-web"},
+	{"nfq", "ips"},
+}
+
+func serviceUnit(name string) (string, bool) {
+	for _, s := range services {
+		if s.name == name {
+			return s.unit, true
+		}
+	}
+	return "", false
+}
+
+// ProcessSample is what StatsProvider reports about one process. CPUTime
+// is user+system time in seconds; FDs is -1 when /proc/<pid>/fd is not
+// readable (the unit runs as another user).
+type ProcessSample struct {
+	CPUTime    float64
+	RSS        uint64
+	FDs        int32
+	Threads    int32
+	CreateTime time.Time
+}
+
+// ProcessStats describes the main process of a service. Restarts is
+// systemd's NRestarts; PIDChanges counts main PID changes seen by the
+// dashboard, which also catches manual restarts.
+type ProcessStats struct {
+	Service    string  `json:"service"`
+	Unit       string  `json:"unit"`
+	State      string  `json:"state"`
+	PID        int32   `json:"pid"`
+	CPU        float64 `json:"cpu"`
+	RSS        uint64  `json:"rss"`
+	FDs        int32   `json:"fds"`
+	Threads    int32   `json:"threads"`
+	StartTime  int64   `json:"startTime,omitempty"`
+	Restarts   uint64  `json:"restarts"`
+	PIDChanges uint64  `json:"pidChanges"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type processTrack struct {
+	pid        int32
+	cpuTime    float64
+	time       time.Time
+	pidChanges uint64
+}
+
+type unitState struct {
+	activeState string
+	mainPID     int32
+	restarts    uint64
+}
+
+const (
+	systemctlTimeout = 5 * time.Second
+	// unitsRefreshInterval is how long the sampler reuses systemd's answer;
+	// in between only /proc is read for the known main PIDs.
+	unitsRefreshInterval = 15 * time.Second
+)
+
+// showUnits asks systemd for the state of every service in one call.
+func (d *Dashboard) showUnits() (map[string]unitState, error) {
+	args := []string{"show", "--property=Id,ActiveState,MainPID,NRestarts"}
+	for _, s := range services {
+		args = append(args, s.unit+".service")
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, systemctlTimeout)
+	defer cancel()
+	out, err := d.commands.Output(ctx, "systemctl", args...)
+	if err != nil {
+		return nil, fmt.Errorf("systemctl show: %v", err)
+	}
+	return parseSystemctlShow(out), nil
+}
+
+// parseSystemctlShow parses blank-line separated blocks of key=value
+// properties into unit states keyed by unit name without ".service".
+func parseSystemctlShow(out []byte) map[string]unitState {
+	units := make(map[string]unitState)
+	var id string
+	var state unitState
+
+	flush := func() {
+		if id != "" {
+			units[strings.TrimSuffix(id, ".service")] = state
+		}
+		id, state = "", unitState{}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Id":
+			id = value
+		case "ActiveState":
+			state.activeState = value
+		case "MainPID":
+			pid, _ := strconv.ParseInt(value, 10, 32)
+			state.mainPID = int32(pid)
+		case "NRestarts":
+			state.restarts, _ = strconv.ParseUint(value, 10, 64)
+		}
+	}
+	flush()
+	return units
+}
+
+// refreshUnits queries systemd and caches the answer for the sampler.
+func (d *Dashboard) refreshUnits(now time.Time) {
+	d.units, d.unitsErr = d.showUnits()
+	d.unitsAt = now
+	if d.unitsErr != nil {
+		slog.Debug("Failed to query systemd units", "error", d.unitsErr)
+	}
+}
+
+// collectProcesses is only called from the stats sampler, so d.processes
+// and the cached units need no lock. systemd is asked again every
+// unitsRefreshInterval, or earlier when a cached main PID has gone.
+func (d *Dashboard) collectProcesses() []ProcessStats {
+	now := d.clock.Now()
+	fresh := false
+	if d.unitsAt.IsZero() || now.Sub(d.unitsAt) >= unitsRefreshInterval || now.Before(d.unitsAt) {
+		d.refreshUnits(now)
+		fresh = true
+	}
+
+	samples := make(map[int32]*ProcessSample)
+	errs := make(map[int32]error)
+	sample := func(pid int32) (*ProcessSample, error) {
+		if _, ok := samples[pid]; !ok {
+			samples[pid], errs[pid] = d.stats.Process(pid)
+		}
+		return samples[pid], errs[pid]
+	}
+	if !fresh {
+		for _, unit := range d.units {
+			if unit.mainPID <= 0 {
+				continue
+			}
+			if _, err := sample(unit.mainPID); err != nil {
+				d.refreshUnits(now)
+				break
+			}
+		}
+	}
+	units, err := d.units, d.unitsErr
+
+	var result []ProcessStats
+	for _, s := range services {
+		p := ProcessStats{Service: s.name, Unit: s.unit, State: "unknown", FDs: -1}
+		track := d.processes[s.name]
+
+		unit, ok := units[s.unit]
+		switch {
+		case err != nil:
+			p.Error = err.Error()
+		case !ok:
+			p.Error = "unit not reported by systemctl"
+		default:
+			p.State = unit.activeState
+			p.PID = unit.mainPID
+			p.Restarts = unit.restarts
+		}
+
+		if p.PID > 0 {
+			if track.pid != 0 && track.pid != p.PID {
+				track.pidChanges++
+			}
+			sample, err := sample(p.PID)
+			if err != nil {
+				p.Error = err.Error()
+			} else {
+				p.RSS = sample.RSS
+				p.FDs = sample.FDs
+				p.Threads = sample.Threads
+				if !sample.CreateTime.IsZero() {
+					p.StartTime = sample.CreateTime.Unix()
+				}
+				if track.pid == p.PID && now.After(track.time) && sample.CPUTime >= track.cpuTime {
+					p.CPU = (sample.CPUTime - track.cpuTime) / now.Sub(track.time).Seconds() * 100
+				}
+				track.cpuTime = sample.CPUTime
+				track.time = now
+			}
+			track.pid = p.PID
+		}
+
+		p.PIDChanges = track.pidChanges
+		d.processes[s.name] = track
+		result = append(result, p)
+	}
+	return result
+}
+
+func (d *Dashboard) processesHandler(w http.ResponseWriter, r *http.Request) {
+	stats := d.statsHub.Latest()
+	if stats == nil {
+		http.Error(w, "Статистика ещё не собрана", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats.Processes)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const configPollInterval = 2 * time.Second
+
+type configReloader struct {
+	overrides *configOverrides
+	dashboard *Dashboard
+	started   *AppConfig
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+func newConfigReloader(overrides *configOverrides, d *Dashboard) *configReloader {
+	cr := &configReloader{overrides: overrides, dashboard: d, started: getConfig()}
+	cr.modTime, cr.size = configFileStamp(d.configPath())
+	return cr
+}
+
+func loadEffectiveConfig(path string, overrides *configOverrides, env configEnv) (*AppConfig, error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	applyOverrides(cfg, overrides)
+	if err := env.validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func configFileStamp(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+func (cr *configReloader) start() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ctx := cr.dashboard.ctx
+	cr.dashboard.wg.Add(2)
+	go func() {
+		defer cr.dashboard.wg.Done()
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				cr.reload("SIGHUP")
+			}
+		}
+	}()
+
+	go cr.watchFile()
+}
+
+func (cr *configReloader) watchFile() {
+	defer cr.dashboard.wg.Done()
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.dashboard.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, size := configFileStamp(cr.dashboard.configPath())
+
+		cr.mu.Lock()
+		changed := !modTime.Equal(cr.modTime) || size != cr.size
+		cr.mu.Unlock()
+
+		if changed && !modTime.IsZero() {
+			cr.reload("file changed")
+		}
+	}
+}
+
+func (cr *configReloader) reload(reason string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.modTime, cr.size = configFileStamp(cr.dashboard.configPath())
+
+	cfg, err := loadEffectiveConfig(cr.dashboard.configPath(), cr.overrides, cr.dashboard.configEnv())
+	if err != nil {
+		slog.Error("Config reload failed, keeping current config", "reason", reason, "error", err)
+		return
+	}
+
+	old := setConfig(cfg)
+	slog.Info("Config reloaded", "reason", reason)
+
+	cr.dashboard.applyConfig(old, cfg)
+}
+
+// save writes cfg to the config file and applies it immediately, keeping
+// environment and flag overrides on top. It returns the effective config.
+func (cr *configReloader) save(cfg *AppConfig) (*AppConfig, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if err := saveConfig(cr.dashboard.configPath(), cfg); err != nil {
+		return nil, err
+	}
+	cr.modTime, cr.size = configFileStamp(cr.dashboard.configPath())
+
+	effective := *cfg
+	effective.Listeners = append([]string(nil), cfg.Listeners...)
+	applyOverrides(&effective, cr.overrides)
+
+	old := setConfig(&effective)
+	slog.Info("Config saved via API")
+
+	cr.dashboard.applyConfig(old, &effective)
+	return &effective, nil
+}
+
+func restartRequiredKeys(old, cfg *AppConfig) []string {
+	keys := []string{}
+	if old.ListenAddress != cfg.ListenAddress {
+		keys = append(keys, "listenAddress")
+	}
+	if old.ListenPort != cfg.ListenPort {
+		keys = append(keys, "listenPort")
+	}
+	if !reflect.DeepEqual(old.Listeners, cfg.Listeners) {
+		keys = append(keys, "listeners")
+	}
+	if old.SocketMode != cfg.SocketMode {
+		keys = append(keys, "socketMode")
+	}
+	if old.LogFormat != cfg.LogFormat {
+		keys = append(keys, "logFormat")
+	}
+	if old.StaticDir != cfg.StaticDir {
+		keys = append(keys, "staticDir")
+	}
+	if old.MetricsDir != cfg.MetricsDir {
+		keys = append(keys, "metricsDir")
+	}
+	return keys
+}
+
+func (d *Dashboard) applyConfig(old, cfg *AppConfig) {
+	if old.LogLevel != cfg.LogLevel {
+		setLogLevel(cfg.LogLevel)
+		slog.Info("Log level changed", "from", old.LogLevel, "to", cfg.LogLevel)
+	}
+
+	if old.Interface != cfg.Interface {
+		slog.Info("Network interface changed", "from", old.Interface, "to", cfg.Interface)
+	}
+
+	if old.NFQ_LOG_FILE != cfg.NFQ_LOG_FILE {
+		slog.Info("NFQ log file changed", "from", old.NFQ_LOG_FILE, "to", cfg.NFQ_LOG_FILE)
+	}
+
+	if keys := restartRequiredKeys(old, cfg); len(keys) > 0 {
+		slog.Warn("Changes take effect after restart", "keys", strings.Join(keys, ", "))
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The metrics store keeps one round-robin file per retention tier. Every
+// file has a fixed size: a header followed by a slot per bucket, and a
+// bucket always lands in slot (time/step) % slots. Records carry their own
+// bucket time and CRC, so a torn write after a power loss only invalidates
+// that one record and stale slots from the previous cycle are skipped.
+
+type rrdTier struct {
+	name  string
+	step  int64
+	slots int64
+}
+
+var rrdTiers = []rrdTier{
+	{"10s", 10, 8640},  // 1 day
+	{"1m", 60, 43200},  // 30 days
+	{"1h", 3600, 8760}, // 1 year
+}
+
+const (
+	rrdMagic      = "GEXRRD1\n"
+	rrdHeaderSize = 4096
+	// Per metric: avg, min, max as float32 and the sample count.
+	rrdValueSize = 16
+	// Records are written as buckets close; fsync is rarer to spare SD
+	// cards; a crash loses at most this much history.
+	rrdSyncInterval = 5 * 60
+)
+
+type rrdValue struct {
+	sum, min, max float64
+	n             uint32
+}
+
+func (v *rrdValue) add(x float64) {
+	if v.n == 0 || x < v.min {
+		v.min = x
+	}
+	if v.n == 0 || x > v.max {
+		v.max = x
+	}
+	v.sum += x
+	v.n++
+}
+
+type rrdFile struct {
+	tier       rrdTier
+	path       string
+	file       *os.File
+	metrics    []string
+	recordSize int64
+
+	bucket int64
+	values []rrdValue
+	dirty  bool
+}
+
+func openRRDFile(dir string, tier rrdTier, metrics []string) (*rrdFile, error) {
+	rf := &rrdFile{
+		tier:       tier,
+		path:       filepath.Join(dir, tier.name+".rrd"),
+		metrics:    metrics,
+		recordSize: 8 + int64(len(metrics))*rrdValueSize + 4,
+		bucket:     -1,
+		values:     make([]rrdValue, len(metrics)),
+	}
+
+	file, err := os.OpenFile(rf.path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return rf, rf.create()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	oldMetrics, err := readRRDHeader(file, tier)
+	if err != nil {
+		file.Close()
+		slog.Warn("Metrics file is unusable, starting a new one", "file", rf.path, "error", err)
+		os.Rename(rf.path, rf.path+".corrupt")
+		return rf, rf.create()
+	}
+	if strings.Join(oldMetrics, "\n") != strings.Join(metrics, "\n") {
+		err := rf.migrate(file, oldMetrics)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		file, err = os.OpenFile(rf.path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rf.file = file
+	return rf, nil
+}
+
+func rrdHeader(tier rrdTier, metrics []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(rrdMagic)
+	binary.Write(&buf, binary.LittleEndian, uint32(tier.step))
+	binary.Write(&buf, binary.LittleEndian, uint32(tier.slots))
+	names := strings.Join(metrics, "\n")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(names)))
+	buf.WriteString(names)
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+func readRRDHeader(file *os.File, tier rrdTier) ([]string, error) {
+	header := make([]byte, rrdHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(rrdMagic)]) != rrdMagic {
+		return nil, errors.New("bad magic")
+	}
+
+	p := len(rrdMagic)
+	step := binary.LittleEndian.Uint32(header[p:])
+	slots := binary.LittleEndian.Uint32(header[p+4:])
+	namesLen := int(binary.LittleEndian.Uint32(header[p+8:]))
+	end := p + 12 + namesLen
+	if end+4 > len(header) {
+		return nil, errors.New("bad header length")
+	}
+	if crc32.ChecksumIEEE(header[:end]) != binary.LittleEndian.Uint32(header[end:]) {
+		return nil, errors.New("header checksum mismatch")
+	}
+	if int64(step) != tier.step || int64(slots) != tier.slots {
+		return nil, fmt.Errorf("tier layout %ds×%d does not match %ds×%d", step, slots, tier.step, tier.slots)
+	}
+	return strings.Split(string(header[p+12:end]), "\n"), nil
+}
+
+// create writes a new empty file next to the final path and renames it
+// into place, so a crash never leaves a half-initialised store behind.
+func (rf *rrdFile) create() error {
+	file, err := rf.createTemp()
+	if err != nil {
+		return err
+	}
+	return rf.install(file)
+}
+
+func (rf *rrdFile) createTemp() (*os.File, error) {
+	header := rrdHeader(rf.tier, rf.metrics)
+	if len(header) > rrdHeaderSize {
+		return nil, fmt.Errorf("too many metrics for %s", rf.path)
+	}
+
+	file, err := os.OpenFile(rf.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(rrdHeaderSize + rf.tier.slots*rf.recordSize); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (rf *rrdFile) install(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := os.Rename(file.Name(), rf.path); err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	return nil
+}
+
+// migrate rewrites a file whose metric list changed, keeping the history
+// of the metrics present in both layouts.
+func (rf *rrdFile) migrate(old *os.File, oldMetrics []string) error {
+	slog.Info("Migrating metrics file", "file", rf.path, "from", len(oldMetrics), "to", len(rf.metrics))
+
+	file, err := rf.createTemp()
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int)
+	for i, name := range oldMetrics {
+		index[name] = i
+	}
+	oldSize := 8 + int64(len(oldMetrics))*rrdValueSize + 4
+
+	reader := bufio.NewReader(io.NewSectionReader(old, rrdHeaderSize, rf.tier.slots*oldSize))
+	buf := make([]byte, oldSize)
+	for slot := int64(0); slot < rf.tier.slots; slot++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			break
+		}
+		bucket, values, ok := decodeRRDRecord(buf, len(oldMetrics))
+		if !ok {
+			continue
+		}
+		mapped := make([]rrdValue, len(rf.metrics))
+		for i, name := range rf.metrics {
+			if j, ok := index[name]; ok {
+				mapped[i] = values[j]
+			}
+		}
+		if _, err := file.WriteAt(encodeRRDRecord(bucket, mapped), rrdHeaderSize+slot*rf.recordSize); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	return os.Rename(rf.path+".tmp", rf.path)
+}
+
+func encodeRRDRecord(bucket int64, values []rrdValue) []byte {
+	buf := make([]byte, 8+len(values)*rrdValueSize+4)
+	binary.LittleEndian.PutUint64(buf, uint64(bucket))
+	for i, v := range values {
+		p := 8 + i*rrdValueSize
+		avg := 0.0
+		if v.n > 0 {
+			avg = v.sum / float64(v.n)
+		}
+		binary.LittleEndian.PutUint32(buf[p:], math.Float32bits(float32(avg)))
+		binary.LittleEndian.PutUint32(buf[p+4:], math.Float32bits(float32(v.min)))
+		binary.LittleEndian.PutUint32(buf[p+8:], math.Float32bits(float32(v.max)))
+		binary.LittleEndian.PutUint32(buf[p+12:], v.n)
+	}
+	end := len(buf) - 4
+	binary.LittleEndian.PutUint32(buf[end:], crc32.ChecksumIEEE(buf[:end]))
+	return buf
+}
+
+func decodeRRDRecord(buf []byte, metrics int) (int64, []rrdValue, bool) {
+	end := len(buf) - 4
+	bucket := int64(binary.LittleEndian.Uint64(buf))
+	if bucket == 0 || crc32.ChecksumIEEE(buf[:end]) != binary.LittleEndian.Uint32(buf[end:]) {
+		return 0, nil, false
+	}
+
+	values := make([]rrdValue, metrics)
+	for i := range values {
+		p := 8 + i*rrdValueSize
+		n := binary.LittleEndian.Uint32(buf[p+12:])
+		avg := float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[p:])))
+		values[i] = rrdValue{
+			sum: avg * float64(n),
+			min: float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[p+4:]))),
+			max: float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[p+8:]))),
+			n:   n,
+		}
+	}
+	return bucket, values, true
+}
+
+func (rf *rrdFile) offset(bucket int64) int64 {
+	return rrdHeaderSize + (bucket/rf.tier.step)%rf.tier.slots*rf.recordSize
+}
+
+func (rf *rrdFile) readBucket(bucket int64) ([]rrdValue, bool) {
+	buf := make([]byte, rf.recordSize)
+	if _, err := rf.file.ReadAt(buf, rf.offset(bucket)); err != nil {
+		return nil, false
+	}
+	stored, values, ok := decodeRRDRecord(buf, len(rf.metrics))
+	return values, ok && stored == bucket
+}
+
+func (rf *rrdFile) add(t int64, values []float64, present []bool) error {
+	bucket := t - t%rf.tier.step
+	if bucket != rf.bucket {
+		if err := rf.flush(); err != nil {
+			return err
+		}
+		rf.bucket = bucket
+		// Continue a bucket that was partly written before a restart.
+		if stored, ok := rf.readBucket(bucket); ok {
+			copy(rf.values, stored)
+		} else {
+			for i := range rf.values {
+				rf.values[i] = rrdValue{}
+			}
+		}
+	}
+
+	for i, v := range values {
+		if present[i] {
+			rf.values[i].add(v)
+		}
+	}
+	rf.dirty = true
+	return nil
+}
+
+func (rf *rrdFile) flush() error {
+	if !rf.dirty {
+		return nil
+	}
+	rf.dirty = false
+	_, err := rf.file.WriteAt(encodeRRDRecord(rf.bucket, rf.values), rf.offset(rf.bucket))
+	return err
+}
+
+// scan calls fn for every valid bucket in [from, to].
+func (rf *rrdFile) scan(from, to int64, fn func(bucket int64, values []rrdValue)) error {
+	reader := bufio.NewReader(io.NewSectionReader(rf.file, rrdHeaderSize, rf.tier.slots*rf.recordSize))
+	buf := make([]byte, rf.recordSize)
+	for slot := int64(0); slot < rf.tier.slots; slot++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return err
+		}
+		bucket, values, ok := decodeRRDRecord(buf, len(rf.metrics))
+		if ok && bucket >= from && bucket <= to && (bucket/rf.tier.step)%rf.tier.slots == slot {
+			fn(bucket, values)
+		}
+	}
+	return nil
+}
+
+// metricsStore persists the samples recorded in the in-memory history into
+// the retention tiers.
+type metricsStore struct {
+	mu      sync.Mutex
+	metrics []string
+	index   map[string]int
+	tiers   []*rrdFile
+	synced  int64
+}
+
+func openMetricsStore(dir string, metrics []string) (*metricsStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &metricsStore{metrics: metrics, index: make(map[string]int)}
+	for i, name := range metrics {
+		s.index[name] = i
+	}
+	for _, tier := range rrdTiers {
+		rf, err := openRRDFile(dir, tier, metrics)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s: %v", tier.name, err)
+		}
+		s.tiers = append(s.tiers, rf)
+	}
+	return s, nil
+}
+
+func (s *metricsStore) Add(sample *historySample) {
+	values := make([]float64, len(s.metrics))
+	present := make([]bool, len(s.metrics))
+	for i, name := range s.metrics {
+		if column, ok := historyColumn(name); ok && !math.IsNaN(sample.Values[column]) {
+			values[i], present[i] = sample.Values[column], true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rf := range s.tiers {
+		if err := rf.add(sample.Time, values, present); err != nil {
+			slog.Warn("Failed to write metrics", "file", rf.path, "error", err)
+		}
+	}
+
+	if sample.Time-s.synced >= rrdSyncInterval {
+		s.synced = sample.Time
+		for _, rf := range s.tiers {
+			if err := rf.file.Sync(); err != nil {
+				slog.Warn("Failed to sync metrics", "file", rf.path, "error", err)
+			}
+		}
+	}
+}
+
+// Retention reports the longest range any tier covers.
+func (s *metricsStore) Retention() int64 {
+	var longest int64
+	for _, rf := range s.tiers {
+		if r := rf.tier.step * rf.tier.slots; r > longest {
+			longest = r
+		}
+	}
+	return longest
+}
+
+// Query aggregates metric over [from, to] from the finest tier that still
+// covers the range, in buckets of at least that tier's step.
+func (s *metricsStore) Query(metric string, from, to, step int64) ([]historyPoint, string, int64, error) {
+	i, ok := s.index[metric]
+	if !ok {
+		return nil, "", 0, fmt.Errorf("unknown metric %q", metric)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rf := s.tiers[len(s.tiers)-1]
+	for _, candidate := range s.tiers {
+		if candidate.tier.step*candidate.tier.slots >= to-from {
+			rf = candidate
+			break
+		}
+	}
+	if step < rf.tier.step {
+		step = rf.tier.step
+	}
+	step -= step % rf.tier.step
+
+	if err := rf.flush(); err != nil {
+		return nil, "", 0, err
+	}
+
+	buckets := make(map[int64]*rrdValue)
+	err := rf.scan(from-from%rf.tier.step, to, func(bucket int64, values []rrdValue) {
+		v := values[i]
+		if v.n == 0 {
+			return
+		}
+		start := bucket - bucket%step
+		agg, ok := buckets[start]
+		if !ok {
+			agg = &rrdValue{min: v.min, max: v.max}
+			buckets[start] = agg
+		}
+		agg.sum += v.sum
+		agg.n += v.n
+		agg.min = math.Min(agg.min, v.min)
+		agg.max = math.Max(agg.max, v.max)
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	points := make([]historyPoint, 0, len(buckets))
+	for start, v := range buckets {
+		points = append(points, historyPoint{
+			Time:  start,
+			Avg:   v.sum / float64(v.n),
+			Min:   v.min,
+			Max:   v.max,
+			Count: int(v.n),
+		})
+	}
+	sort.Slice(points, func(a, b int) bool { return points[a].Time < points[b].Time })
+	return points, rf.tier.name, step, nil
+}
+
+// Rows returns the buckets of the finest tier within [from, to] as history
+// rows, each metric averaged over its bucket, oldest first.
+func (s *metricsStore) Rows(from, to int64) ([]historyRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rf := s.tiers[0]
+	if err := rf.flush(); err != nil {
+		return nil, err
+	}
+
+	var rows []historyRow
+	err := rf.scan(from-from%rf.tier.step, to, func(bucket int64, values []rrdValue) {
+		row := historyRow{Time: bucket, Values: make([]float32, len(historyColumns))}
+		for i := range row.Values {
+			row.Values[i] = float32(math.NaN())
+		}
+		for i, name := range s.metrics {
+			if column, ok := historyColumn(name); ok && values[i].n > 0 {
+				row.Values[column] = float32(values[i].sum / float64(values[i].n))
+			}
+		}
+		rows = append(rows, row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rows, func(a, b int) bool { return rows[a].Time < rows[b].Time })
+	return rows, nil
+}
+
+func (s *metricsStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, rf := range s.tiers {
+		if err := rf.flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := rf.file.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := rf.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.tiers = nil
+	return firstErr
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func cpuSample(t int64, cpu float64) *historySample {
+	return newHistorySample(&SystemStats{Timestamp: t, CPU: cpu})
+}
+
+func TestMetricsStoreSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	const start = 1700000000
+
+	store, err := openMetricsStore(dir, []string{"cpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two minutes of samples every 2 s, cpu rising by one per sample.
+	for i := int64(0); i < 60; i++ {
+		store.Add(cpuSample(start+i*2, float64(i)))
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openMetricsStore(dir, []string{"cpu", "ram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	points, tier, step, err := store.Query("cpu", start-start%60, start+120, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tier != "10s" || step != 60 {
+		t.Errorf("tier %s, step %d", tier, step)
+	}
+	count := 0
+	for _, p := range points {
+		count += p.Count
+	}
+	if count != 60 || points[0].Min != 0 || points[len(points)-1].Max != 59 {
+		t.Errorf("points after reopen: %+v", points)
+	}
+
+	points, tier, _, err = store.Query("cpu", start-start%3600, start+3600, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tier != "10s" || len(points) != 1 || points[0].Count != 60 || points[0].Avg != 29.5 {
+		t.Errorf("hour bucket from %s: %+v", tier, points)
+	}
+}
+
+func TestMetricsStoreIgnoresTornRecords(t *testing.T) {
+	dir := t.TempDir()
+	const bucket = 1700000000
+
+	store, err := openMetricsStore(dir, []string{"cpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Add(cpuSample(bucket, 10))
+	store.Add(cpuSample(bucket+10, 20))
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the second 10 s record as a power loss mid-write would.
+	rf := &rrdFile{tier: rrdTiers[0], recordSize: 8 + rrdValueSize + 4}
+	file, err := os.OpenFile(filepath.Join(dir, "10s.rrd"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte{0xff, 0xff}, rf.offset(bucket+10)+10)
+	file.Close()
+
+	store, err = openMetricsStore(dir, []string{"cpu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	points, _, _, err := store.Query("cpu", bucket, bucket+60, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Time != bucket || points[0].Avg != 10 {
+		t.Errorf("points = %+v", points)
+	}
+}
+
+func TestPersistentMetricsAreHistoryMetrics(t *testing.T) {
+	for _, name := range persistentMetrics {
+		if _, ok := historyMetrics[name]; !ok {
+			t.Errorf("persistent metric %s is not a history metric", name)
+		}
+	}
+}
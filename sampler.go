@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const defaultStatsInterval = 2 * time.Second
+
+// hub keeps the latest snapshot and fans it out to the subscribed
+// /ws/stats clients. Each subscriber holds at most one pending snapshot,
+// so a slow client only ever skips stale samples.
+type hub[T any] struct {
+	mu          sync.RWMutex
+	latest      T
+	subscribers map[chan T]struct{}
+}
+
+func newHub[T any]() *hub[T] {
+	return &hub[T]{subscribers: make(map[chan T]struct{})}
+}
+
+func (h *hub[T]) Latest() T {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.latest
+}
+
+func (h *hub[T]) Subscribe() chan T {
+	ch := make(chan T, 1)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub[T]) Unsubscribe(ch chan T) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+func (h *hub[T]) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+func (h *hub[T]) publish(value T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest = value
+	for ch := range h.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- value
+	}
+}
+
+func (d *Dashboard) sampleStats() {
+	stats, err := d.getSystemStats()
+	if err != nil {
+		slog.Error("Failed to collect system stats", "error", err)
+		return
+	}
+	sample := d.recordHistory(stats)
+	d.alerts.evaluate(sample)
+	d.statsHub.publish(stats)
+}
+
+func (d *Dashboard) runStatsSampler(interval time.Duration) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.sampleStats()
+		}
+	}
+}
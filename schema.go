@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const nfqSchemaSuffix = ".schema.json"
+
+// jsonSchema is the subset of JSON Schema used to check the NFQ engine
+// config: type, enum, const, properties, required, additionalProperties,
+// items, numeric and length bounds and pattern.
+type jsonSchema struct {
+	Type                 json.RawMessage        `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                interface{}            `json:"const"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Pattern              string                 `json:"pattern"`
+
+	types        []string
+	additional   *jsonSchema
+	noAdditional bool
+	pattern      *regexp.Regexp
+}
+
+type schemaError struct {
+	Pointer string `json:"pointer"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// nfqSchemaPath returns the schema file kept next to the NFQ config,
+// e.g. /root/nfq/config.schema.json for /root/nfq/config.json.
+func nfqSchemaPath(configPath string) string {
+	ext := filepath.Ext(configPath)
+	return strings.TrimSuffix(configPath, ext) + nfqSchemaSuffix
+}
+
+// loadJSONSchema reads and compiles a schema; a missing file yields nil.
+func loadJSONSchema(path string) (*jsonSchema, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var schema jsonSchema
+	if err := json.Unmarshal(content, &schema); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := schema.compile(""); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &schema, nil
+}
+
+func (s *jsonSchema) compile(ptr string) error {
+	if len(s.Type) > 0 {
+		if err := json.Unmarshal(s.Type, &s.types); err != nil {
+			var single string
+			if err := json.Unmarshal(s.Type, &single); err != nil {
+				return fmt.Errorf("%s/type: must be a string or an array of strings", ptr)
+			}
+			s.types = []string{single}
+		}
+	}
+
+	if len(s.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(s.AdditionalProperties, &allowed); err == nil {
+			s.noAdditional = !allowed
+		} else {
+			s.additional = &jsonSchema{}
+			if err := json.Unmarshal(s.AdditionalProperties, s.additional); err != nil {
+				return fmt.Errorf("%s/additionalProperties: %v", ptr, err)
+			}
+			if err := s.additional.compile(ptr + "/additionalProperties"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s/pattern: %v", ptr, err)
+		}
+		s.pattern = re
+	}
+
+	for name, prop := range s.Properties {
+		if err := prop.compile(ptr + "/properties/" + escapePointer(name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(ptr + "/items"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateJSONDocument checks that data is a single JSON value and, when
+// schema is not nil, that it conforms to the schema.
+func validateJSONDocument(data []byte, schema *jsonSchema) []schemaError {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return []schemaError{syntaxError(data, err, dec.InputOffset())}
+	}
+	// Anything but whitespace after the value is an error, including stray
+	// delimiters that Decoder.Token would skip or fail on.
+	if rest := bytes.TrimLeft(data[dec.InputOffset():], " \t\r\n"); len(rest) > 0 {
+		line, col := lineColumn(data, int64(len(data)-len(rest)))
+		return []schemaError{{Line: line, Column: col, Message: "лишние данные после JSON-значения"}}
+	}
+
+	if schema == nil {
+		return nil
+	}
+
+	var errs []schemaError
+	schema.validate(doc, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	offsets := locatePointers(data)
+	for i := range errs {
+		errs[i].Line, errs[i].Column = lineColumn(data, offsets[errs[i].Pointer])
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Column < errs[j].Column
+	})
+	return errs
+}
+
+func syntaxError(data []byte, err error, fallback int64) schemaError {
+	offset := fallback
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		offset = syntax.Offset
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("пустой документ")
+	}
+	line, col := lineColumn(data, offset)
+	return schemaError{Line: line, Column: col, Message: "синтаксическая ошибка: " + err.Error()}
+}
+
+func (s *jsonSchema) validate(value interface{}, ptr string, errs *[]schemaError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, schemaError{Pointer: ptr, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !matchesAnyType(value, s.types) {
+		fail("ожидается %s, получено %s", strings.Join(s.types, " или "), jsonTypeName(value))
+		return
+	}
+
+	if s.Enum != nil {
+		found := false
+		for _, allowed := range s.Enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("значение должно быть одним из %s", formatJSONList(s.Enum))
+		}
+	}
+	if s.Const != nil && !jsonEqual(value, s.Const) {
+		fail("значение должно быть равно %s", formatJSONList([]interface{}{s.Const}))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("отсутствует обязательное поле %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPtr := ptr + "/" + escapePointer(name)
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(v[name], childPtr, errs)
+			} else if s.noAdditional {
+				*errs = append(*errs, schemaError{Pointer: childPtr, Message: fmt.Sprintf("неизвестное поле %q", name)})
+			} else if s.additional != nil {
+				s.additional.validate(v[name], childPtr, errs)
+			}
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("элементов должно быть не меньше %d", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("элементов должно быть не больше %d", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, ptr+"/"+strconv.Itoa(i), errs)
+			}
+		}
+
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("длина строки должна быть не меньше %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("длина строки должна быть не больше %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("строка не соответствует шаблону %s", s.Pattern)
+		}
+
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("значение должно быть не меньше %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("значение должно быть не больше %v", *s.Maximum)
+		}
+	}
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		if t == jsonTypeName(value) || t == "number" && jsonTypeName(value) == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) && !strings.ContainsAny(v.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a, b interface{}) bool {
+	if n, ok := a.(json.Number); ok {
+		f, _ := n.Float64()
+		a = f
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func formatJSONList(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		data, _ := json.Marshal(v)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// locatePointers maps the JSON pointer of every value in data to the byte
+// offset where the value starts.
+func locatePointers(data []byte) map[string]int64 {
+	offsets := make(map[string]int64)
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(ptr string) error
+	walk = func(ptr string) error {
+		offsets[ptr] = skipSeparators(data, dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name, _ := key.(string)
+				if err := walk(ptr + "/" + escapePointer(name)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(ptr + "/" + strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	walk("")
+	return offsets
+}
+
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// lineColumn converts a byte offset into a 1-based line and column, counting
+// columns in characters as the browser editor does.
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
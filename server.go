@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unixListenerPrefix = "unix:"
+
+// defaultSocketMode lets a reverse proxy in the service's group connect;
+// the process umask alone would leave the socket owner-writable only.
+const defaultSocketMode os.FileMode = 0660
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 2 * time.Minute
+	shutdownTimeout   = 10 * time.Second
+)
+
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+func listenAddrs(cfg *AppConfig) []string {
+	if len(cfg.Listeners) > 0 {
+		return cfg.Listeners
+	}
+	return []string{net.JoinHostPort(cfg.ListenAddress, cfg.ListenPort)}
+}
+
+func socketMode(cfg *AppConfig) (os.FileMode, error) {
+	if cfg.SocketMode == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal permission mode", cfg.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func openListener(addr string, mode os.FileMode) (net.Listener, error) {
+	if strings.HasPrefix(addr, unixListenerPrefix) {
+		path := strings.TrimPrefix(addr, unixListenerPrefix)
+		if path == "" {
+			return nil, fmt.Errorf("empty unix socket path in %q", addr)
+		}
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func openListeners(addrs []string, mode os.FileMode) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		l, err := openListener(addr, mode)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listenerURL(l net.Listener) string {
+	if l.Addr().Network() == "unix" {
+		return unixListenerPrefix + l.Addr().String()
+	}
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return l.Addr().String()
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
+
+// serveAll serves srv on every listener and reports the first failure.
+// Listeners closed by srv.Shutdown are not reported.
+func serveAll(srv *http.Server, listeners []net.Listener) <-chan error {
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				errc <- err
+			}
+		}(l)
+	}
+	return errc
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestUnixListenerMode(t *testing.T) {
+	// Mimic systemd's default UMask=0022.
+	defer syscall.Umask(syscall.Umask(0022))
+
+	for _, tc := range []struct {
+		socketMode string
+		want       os.FileMode
+	}{
+		{"", 0660},
+		{"0666", 0666},
+	} {
+		mode, err := socketMode(&AppConfig{SocketMode: tc.socketMode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "dashboard.sock")
+		l, err := openListener(unixListenerPrefix+path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tc.want {
+			t.Errorf("socketMode %q: socket mode %o, want %o", tc.socketMode, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"rw", "0888", "1777"} {
+		if _, err := socketMode(&AppConfig{SocketMode: bad}); err == nil {
+			t.Errorf("socketMode %q accepted", bad)
+		}
+	}
+}
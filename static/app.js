@@ -20,25 +20,38 @@ function initWebSocket() {
             
             ws.onmessage = function(event) {
                 const data = JSON.parse(event.data);
-                updateStats(data);
+                if (data.type === 'packet_stats') {
+                    renderPacketStats(data.packetStats);
+                } else {
+                    updateStats(data);
+                }
             };
             
             ws.onerror = function(error) {
                 console.error('WebSocket error:', error);
-                setInterval(updateStatsHttp, 5000);
+                startHttpPolling();
             };
             
             ws.onclose = function() {
                 console.log('WebSocket closed, using HTTP polling');
-                setInterval(updateStatsHttp, 5000);
+                startHttpPolling();
             };
         } catch (e) {
             console.error('WebSocket not supported, using HTTP polling');
-            setInterval(updateStatsHttp, 5000);
+            startHttpPolling();
         }
     }
 }
 
+let httpPolling = false;
+
+function startHttpPolling() {
+    if (httpPolling) return;
+    httpPolling = true;
+    setInterval(updateStatsHttp, 5000);
+    setInterval(updatePacketStats, 5000);
+}
+
 function updateStatsHttp() {
     fetch('/api/stats')
         .then(response => response.json())
@@ -47,11 +60,17 @@ function updateStatsHttp() {
 }
 
 function updateStats(stats) {
+    const speed = (stats.interfaces && stats.interfaces[stats.interface]) || { download: 0, upload: 0 };
+    const temps = (stats.temperatures || []).map(t => t.celsius);
+    const throttling = stats.throttling || {};
     const elements = {
+        'temp-value': temps.length ? `${Math.max(...temps).toFixed(1)}°C` : '—',
+        'temp-label': throttling.throttled ? 'Температура (троттлинг)' : 'Температура',
+        'load-value': stats.load ? stats.load.load1.toFixed(2) : '0.00',
         'cpu-value': `${stats.cpu.toFixed(1)}%`,
         'ram-value': `${stats.ram.toFixed(1)}%`,
         'disk-value': `${stats.disk.toFixed(1)}%`,
-        'network-value': `${((stats.speed.download + stats.speed.upload) / 1024 / 1024).toFixed(2)} MB/s`
+        'network-value': `${((speed.download + speed.upload) / 1024 / 1024).toFixed(2)} MB/s`
     };
     
     Object.entries(elements).forEach(([id, value]) => {
@@ -69,26 +88,142 @@ function updateStats(stats) {
         const element = document.getElementById(id);
         if (element) element.style.width = `${width}%`;
     });
+
+    updateMounts(stats.mounts || []);
+    updateEngine(stats.engine, stats.engineSource);
+}
+
+// updateEngine shows what the NFQ engine reports about itself; the
+// latency is the worst queue and the utilisation the mean over workers.
+function updateEngine(engine, source) {
+    const queues = Object.values((engine && engine.queues) || {});
+    const workers = Object.values((engine && engine.workers) || {});
+    const latencyAvg = Math.max(0, ...queues.map(q => q.latencyAvgMs));
+    const latencyP99 = Math.max(0, ...queues.map(q => q.latencyP99Ms));
+    const utilization = workers.reduce((sum, w) => sum + w.utilization, 0) / (workers.length || 1);
+    const elements = {
+        'engine-cpu': engine ? `${engine.cpu.toFixed(1)}%` : '—',
+        'engine-memory': engine ? `${(engine.memory / 1024 / 1024).toFixed(1)} MB` : '—',
+        'engine-latency': engine && queues.length ? `${latencyAvg.toFixed(2)} мс` : '—',
+        'engine-latency-label': engine && queues.length
+            ? `Задержка очереди (p99 ${latencyP99.toFixed(2)} мс)`
+            : 'Задержка очереди',
+        'engine-workers': engine && workers.length ? `${utilization.toFixed(0)}%` : '—',
+        'engine-source': describeSource(source)
+    };
+
+    Object.entries(elements).forEach(([id, value]) => {
+        const element = document.getElementById(id);
+        if (element) element.textContent = value;
+    });
+}
+
+function updateMounts(mounts) {
+    const grid = document.getElementById('mounts-grid');
+    if (!grid) return;
+
+    grid.innerHTML = '';
+    mounts.forEach(mount => {
+        const card = document.createElement('div');
+        card.className = 'stat-card' + (mount.warning ? ' stat-warning' : '');
+
+        const value = document.createElement('div');
+        value.className = 'stat-value';
+        value.textContent = mount.error ? '—' : `${mount.usedPercent.toFixed(1)}%`;
+
+        const label = document.createElement('div');
+        label.className = 'stat-label';
+        label.textContent = mount.error
+            ? `${mount.path}: ${mount.error}`
+            : `${mount.path} (inode ${mount.inodesUsedPercent.toFixed(0)}%)`;
+
+        card.append(value, label);
+        grid.appendChild(card);
+    });
+}
+
+const knownPacketFields = new Set([
+    'version', 'total', 'passed', 'blocked', 'bytes', 'protocols', 'queues', 'rules', 'rates',
+    'status', 'file', 'mtime', 'age', 'stale', 'error'
+]);
+
+function statCard(value, label) {
+    const card = document.createElement('div');
+    card.className = 'stat-card';
+
+    const valueElement = document.createElement('div');
+    valueElement.className = 'stat-value';
+    valueElement.textContent = value;
+
+    const labelElement = document.createElement('div');
+    labelElement.className = 'stat-label';
+    labelElement.textContent = label;
+
+    card.append(valueElement, labelElement);
+    return card;
+}
+
+// updatePacketBreakdown shows protocols, queues, the busiest rules and any
+// numeric fields the engine added that the dashboard does not know yet.
+function updatePacketBreakdown(data) {
+    const grid = document.getElementById('packet-breakdown');
+    if (!grid) return;
+
+    grid.innerHTML = '';
+    if (data.status === 'unavailable') return;
+
+    Object.entries(data.protocols || {}).forEach(([protocol, counters]) => {
+        grid.appendChild(statCard(counters.packets, `${protocol.toUpperCase()} (заблокировано ${counters.blocked})`));
+    });
+    Object.entries(data.queues || {}).forEach(([queue, counters]) => {
+        const dropped = counters.dropped ? `, потеряно ${counters.dropped}` : '';
+        grid.appendChild(statCard(counters.packets, `Очередь ${queue}${dropped}`));
+    });
+    Object.entries(data.rules || {})
+        .sort((a, b) => b[1].hits - a[1].hits)
+        .slice(0, 5)
+        .forEach(([id, counters]) => grid.appendChild(statCard(counters.hits, `Срабатываний: ${id}`)));
+    Object.entries(data)
+        .filter(([name, value]) => !knownPacketFields.has(name) && typeof value === 'number')
+        .forEach(([name, value]) => grid.appendChild(statCard(value, name)));
+}
+
+function describeSource(source) {
+    if (!source || source.status === 'ok') return '';
+    if (source.status === 'stale') {
+        return `Данные устарели: ${source.file} не обновлялся ${Math.round(source.age)} с`;
+    }
+    return `Нет данных: ${source.error || source.file}`;
 }
 
 function updatePacketStats() {
     fetch('/api/packet-stats')
         .then(response => response.json())
-        .then(data => {
-            const elements = {
-                'packets-total': data.total,
-                'packets-passed': data.passed,
-                'packets-blocked': data.blocked
-            };
-            
-            Object.entries(elements).forEach(([id, value]) => {
-                const element = document.getElementById(id);
-                if (element) element.textContent = value;
-            });
-        })
+        .then(data => renderPacketStats(data))
         .catch(error => console.error('Packet stats error:', error));
 }
 
+function renderPacketStats(data) {
+    const available = data.status !== 'unavailable';
+    const minute = (data.rates && data.rates.windows && data.rates.windows['1m']) || { blocked: 0, blockRatio: 0 };
+    const elements = {
+        'packets-total': available ? data.total : '—',
+        'packets-passed': available ? data.passed : '—',
+        'packets-blocked': available ? data.blocked : '—',
+        'packets-blocked-rate': available ? `${minute.blocked.toFixed(1)}/с` : '—',
+        'packets-block-ratio': available
+            ? `Блокируется за 1 мин (${(minute.blockRatio * 100).toFixed(1)}%)`
+            : 'Блокируется за 1 мин',
+        'packets-source': describeSource(data)
+    };
+    
+    Object.entries(elements).forEach(([id, value]) => {
+        const element = document.getElementById(id);
+        if (element) element.textContent = value;
+    });
+    updatePacketBreakdown(data);
+}
+
 function restartService(service) {
     if (confirm(`Вы уверены, что хотите перезапустить службу ${service}?`)) {
         fetch(`/api/restart/${service}`, {method: 'POST'})
@@ -295,7 +430,6 @@ document.addEventListener('DOMContentLoaded', function() {
         case '':
             initWebSocket();
             updatePacketStats();
-            setInterval(updatePacketStats, 5000);
             break;
             
         case 'logs.html':
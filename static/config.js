@@ -45,10 +45,23 @@ function loadConfig() {
         });
 }
 
-function saveConfig() {
+function escapeHtml(text) {
+    const div = document.createElement('div');
+    div.textContent = text;
+    return div.innerHTML;
+}
+
+function formatValidationErrors(errors) {
+    return errors.map(err => {
+        const where = 'строка ' + err.line + ', столбец ' + err.column + (err.pointer ? ' (' + err.pointer + ')' : '');
+        return escapeHtml(where + ': ' + err.message);
+    }).join('<br>');
+}
+
+function saveConfig(force = false) {
     const configContent = configEditor.getValue();
     
-    fetch('/api/config', {
+    fetch('/api/config' + (force ? '?force=true' : ''), {
         method: 'POST',
         headers: {
             'Content-Type': 'application/json'
@@ -59,6 +72,13 @@ function saveConfig() {
     .then(data => {
         if (data.success) {
             showMessage('Конфигурация успешно сохранена');
+        } else if (data.errors && data.errors.length > 0) {
+            showMessage(escapeHtml(data.error) + ':<br>' + formatValidationErrors(data.errors), 'error');
+            configEditor.setCursor({ line: data.errors[0].line - 1, ch: data.errors[0].column - 1 });
+            configEditor.focus();
+            if (confirm('Конфигурация содержит ошибки и может остановить службу ips. Всё равно сохранить?')) {
+                saveConfig(true);
+            }
         } else {
             showMessage(data.error || 'Ошибка сохранения', 'error');
         }
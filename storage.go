@@ -0,0 +1,171 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const defaultDiskWarnPercent = 90
+
+// MountStats is the space and inode usage of one configured mount point.
+// Warning is set when either passes AppConfig.DiskWarnPercent.
+type MountStats struct {
+	Path              string  `json:"path"`
+	Fstype            string  `json:"fstype,omitempty"`
+	Total             uint64  `json:"total"`
+	Used              uint64  `json:"used"`
+	Free              uint64  `json:"free"`
+	UsedPercent       float64 `json:"usedPercent"`
+	InodesTotal       uint64  `json:"inodesTotal"`
+	InodesUsed        uint64  `json:"inodesUsed"`
+	InodesUsedPercent float64 `json:"inodesUsedPercent"`
+	Warning           bool    `json:"warning"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// DiskIOStats holds per-second rates of one block device; Busy is the
+// share of the interval the device spent doing I/O, in percent.
+type DiskIOStats struct {
+	ReadBytes  float64 `json:"readBytes"`
+	WriteBytes float64 `json:"writeBytes"`
+	ReadIOPS   float64 `json:"readIOPS"`
+	WriteIOPS  float64 `json:"writeIOPS"`
+	Busy       float64 `json:"busy"`
+	Interval   float64 `json:"interval"`
+	Reset      bool    `json:"reset,omitempty"`
+}
+
+type diskIOSample struct {
+	counters map[string]disk.IOCountersStat
+	time     time.Time
+}
+
+func configuredMounts(cfg *AppConfig) []string {
+	if len(cfg.Mounts) == 0 {
+		return []string{"/"}
+	}
+	return cfg.Mounts
+}
+
+func diskWarnPercent(cfg *AppConfig) float64 {
+	if cfg.DiskWarnPercent <= 0 {
+		return defaultDiskWarnPercent
+	}
+	return float64(cfg.DiskWarnPercent)
+}
+
+func (d *Dashboard) collectMounts(cfg *AppConfig) []MountStats {
+	threshold := diskWarnPercent(cfg)
+
+	var mounts []MountStats
+	for _, path := range configuredMounts(cfg) {
+		m := MountStats{Path: path}
+		usage, err := d.stats.DiskUsage(d.path(path))
+		if err != nil {
+			m.Error = err.Error()
+			m.Warning = true
+			mounts = append(mounts, m)
+			continue
+		}
+
+		m.Fstype = usage.Fstype
+		m.Total = usage.Total
+		m.Used = usage.Used
+		m.Free = usage.Free
+		m.UsedPercent = usage.UsedPercent
+		m.InodesTotal = usage.InodesTotal
+		m.InodesUsed = usage.InodesUsed
+		m.InodesUsedPercent = usage.InodesUsedPercent
+		m.Warning = m.UsedPercent >= threshold || m.InodesUsedPercent >= threshold
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+// isVirtualBlockDevice skips devices that never back a mount we care about.
+func isVirtualBlockDevice(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "dm-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+const blockDir = "/sys/class/block"
+
+// isPartition tells partitions from whole disks: their I/O is already
+// counted in the disk's own line of /proc/diskstats.
+func (d *Dashboard) isPartition(name string) bool {
+	_, err := os.Stat(filepath.Join(d.path(blockDir), name, "partition"))
+	return err == nil
+}
+
+// Bounds for unwrapping 32-bit disk counters, see counterDelta.
+const (
+	maxDiskBytesRate = 1e9
+	maxDiskIOPS      = 1e6
+)
+
+// collectDiskIO computes rates against the previous sample. It is only
+// called from the stats sampler, so prevDiskIO needs no lock.
+func (d *Dashboard) collectDiskIO() map[string]DiskIOStats {
+	counters, err := d.stats.DiskIOCounters()
+	if err != nil {
+		slog.Debug("Failed to read disk I/O counters", "error", err)
+		return nil
+	}
+
+	now := d.clock.Now()
+	prev := d.prevDiskIO
+	d.prevDiskIO = diskIOSample{counters: counters, time: now}
+
+	elapsed := now.Sub(prev.time)
+	rates := make(map[string]DiskIOStats)
+	for name, cur := range counters {
+		if isVirtualBlockDevice(name) || d.isPartition(name) {
+			continue
+		}
+		old, ok := prev.counters[name]
+		if !ok || elapsed <= 0 {
+			rates[name] = DiskIOStats{}
+			continue
+		}
+
+		var r DiskIOStats
+		pairs := []struct {
+			prev, cur uint64
+			rate      *float64
+			max       float64
+		}{
+			{old.ReadBytes, cur.ReadBytes, &r.ReadBytes, maxDiskBytesRate},
+			{old.WriteBytes, cur.WriteBytes, &r.WriteBytes, maxDiskBytesRate},
+			{old.ReadCount, cur.ReadCount, &r.ReadIOPS, maxDiskIOPS},
+			{old.WriteCount, cur.WriteCount, &r.WriteIOPS, maxDiskIOPS},
+			// IoTime is in milliseconds, at most one per millisecond.
+			{old.IoTime, cur.IoTime, &r.Busy, 1000},
+		}
+
+		seconds := elapsed.Seconds()
+		for _, p := range pairs {
+			delta, ok := counterDelta(p.prev, p.cur, elapsed, p.max)
+			if !ok {
+				r = DiskIOStats{Reset: true}
+				break
+			}
+			*p.rate = float64(delta) / seconds
+		}
+		if !r.Reset {
+			// IoTime is in milliseconds.
+			r.Busy = r.Busy / 10
+			r.Interval = elapsed.Round(time.Millisecond).Seconds()
+		}
+		rates[name] = r
+	}
+	return rates
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configVersion is the current config.json format. Files without a version
+// field are version 1, which used the log_level/listen_port keys of install.sh.
+const configVersion = 2
+
+var legacyConfigKeys = map[string]string{
+	"log_level":      "logLevel",
+	"listen_port":    "listenPort",
+	"listen_address": "listenAddress",
+}
+
+type configError struct {
+	file     string
+	problems []string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("invalid config %s:\n  - %s", e.file, strings.Join(e.problems, "\n  - "))
+}
+
+func migrateConfig(raw map[string]json.RawMessage) (bool, error) {
+	version := 1
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return false, fmt.Errorf("invalid config version: %s", v)
+		}
+	}
+	if version > configVersion {
+		return false, fmt.Errorf("config version %d is newer than supported version %d", version, configVersion)
+	}
+
+	migrated := version < configVersion
+	for legacy, key := range legacyConfigKeys {
+		value, ok := raw[legacy]
+		if !ok {
+			continue
+		}
+		if _, exists := raw[key]; !exists {
+			raw[key] = value
+		}
+		delete(raw, legacy)
+		migrated = true
+	}
+
+	raw["version"] = json.RawMessage(strconv.Itoa(configVersion))
+	return migrated, nil
+}
+
+func knownConfigKeys() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(AppConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+func normalizeConfigKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+func unknownConfigKeys(raw map[string]json.RawMessage) []string {
+	known := knownConfigKeys()
+
+	var problems []string
+	for key := range raw {
+		if known[key] {
+			continue
+		}
+		problem := fmt.Sprintf("unknown key %q", key)
+		for k := range known {
+			if normalizeConfigKey(k) == normalizeConfigKey(key) {
+				problem += fmt.Sprintf(" (did you mean %q?)", k)
+				break
+			}
+		}
+		problems = append(problems, problem)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// configEnv is where validation looks up the configured paths and
+// interfaces: the host for the startup config, the dashboard's root and
+// stats provider once it runs.
+type configEnv struct {
+	path       func(p string) string
+	interfaces func() ([]string, error)
+}
+
+var hostConfigEnv = configEnv{
+	path:       func(p string) string { return p },
+	interfaces: hostInterfaceNames,
+}
+
+func hostInterfaceNames() ([]string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names, nil
+}
+
+func (d *Dashboard) configEnv() configEnv {
+	return configEnv{path: d.path, interfaces: d.interfaceNames}
+}
+
+func (d *Dashboard) interfaceNames() ([]string, error) {
+	ifaces, err := d.stats.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, iface := range ifaces {
+		names = append(names, iface.Name)
+	}
+	return names, nil
+}
+
+func validateConfig(cfg *AppConfig) error {
+	return hostConfigEnv.validate(cfg)
+}
+
+func (env configEnv) validate(cfg *AppConfig) error {
+	var problems []string
+
+	paths := []struct {
+		key, path string
+	}{
+		{"nfq_log_file", cfg.NFQ_LOG_FILE},
+		{"nfq_config_file", cfg.NFQ_CONFIG_FILE},
+		{"nfq_rules_dir", cfg.NFQ_RULES_DIR},
+	}
+	for _, p := range paths {
+		if err := checkConfigPath(p.path, env.path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", p.key, err))
+		}
+	}
+
+	// The stats files live in a tmpfs the engine creates, so they may not
+	// exist yet; readStatsSource reports them as unavailable meanwhile.
+	statsFiles := []struct {
+		key, path string
+	}{
+		{"net_stats_file", cfg.NET_STATS_FILE},
+		{"sys_stats_file", cfg.SYS_STATS_FILE},
+	}
+	for _, p := range statsFiles {
+		if !filepath.IsAbs(p.path) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not an absolute path", p.key, p.path))
+		}
+	}
+
+	if err := checkInterface(cfg.Interface, env.interfaces); err != nil {
+		problems = append(problems, fmt.Sprintf("interface: %v", err))
+	}
+
+	if !isValidLogLevel(cfg.LogLevel) {
+		problems = append(problems, fmt.Sprintf("logLevel: %q is not one of %s", cfg.LogLevel, "debug, info, warn, error"))
+	}
+
+	if cfg.LogFormat != "" && cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		problems = append(problems, fmt.Sprintf("logFormat: %q is not one of %s", cfg.LogFormat, strings.Join(validLogFormats, ", ")))
+	}
+
+	if cfg.StaticDir != "" {
+		if err := checkStaticDir(env.path(cfg.StaticDir)); err != nil {
+			problems = append(problems, fmt.Sprintf("staticDir: %v", err))
+		}
+	}
+
+	if cfg.MetricsDir != "" {
+		if err := checkConfigPath(cfg.MetricsDir, env.path); err != nil {
+			problems = append(problems, fmt.Sprintf("metricsDir: %v", err))
+		}
+	}
+
+	for _, mount := range cfg.Mounts {
+		if !filepath.IsAbs(mount) {
+			problems = append(problems, fmt.Sprintf("mounts: %q is not an absolute path", mount))
+		} else if info, err := os.Stat(env.path(mount)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("mounts: %s is not a directory", mount))
+		}
+	}
+
+	if cfg.DiskWarnPercent < 0 || cfg.DiskWarnPercent > 100 {
+		problems = append(problems, fmt.Sprintf("diskWarnPercent: %d is not between 1 and 100", cfg.DiskWarnPercent))
+	}
+
+	if cfg.StatsStaleAfter != "" {
+		if d, err := time.ParseDuration(cfg.StatsStaleAfter); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("statsStaleAfter: %q is not a positive duration", cfg.StatsStaleAfter))
+		}
+	}
+
+	if _, err := socketMode(cfg); err != nil {
+		problems = append(problems, fmt.Sprintf("socketMode: %v", err))
+	}
+
+	if len(cfg.Listeners) == 0 {
+		if port, err := strconv.Atoi(cfg.ListenPort); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("listenPort: %q is not a valid port", cfg.ListenPort))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &configError{file: configFile, problems: problems}
+	}
+	return nil
+}
+
+// checkConfigPath accepts a path whose directory exists even if the file
+// itself does not, since missing files are created at startup. Errors
+// name the configured path, not the one under the dashboard root.
+func checkConfigPath(path string, resolve func(p string) string) error {
+	if path == "" {
+		return fmt.Errorf("path is empty")
+	}
+	if _, err := os.Stat(resolve(path)); err == nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(resolve(dir)); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s does not exist and neither does its directory %s", path, dir)
+	}
+	return nil
+}
+
+func checkInterface(name string, list func() ([]string, error)) error {
+	if name == "" {
+		return fmt.Errorf("interface name is empty")
+	}
+	names, err := list()
+	if err != nil {
+		return fmt.Errorf("cannot list interfaces: %v", err)
+	}
+	for _, n := range names {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q not found (available: %s)", name, strings.Join(names, ", "))
+}
+
+func isValidLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
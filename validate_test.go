@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		in       string
+		want     map[string]string
+		migrated bool
+		err      string
+	}{
+		{
+			name:     "legacy keys",
+			in:       `{"log_level": "debug", "listen_port": "9090", "listen_address": "127.0.0.1"}`,
+			want:     map[string]string{"version": "2", "logLevel": `"debug"`, "listenPort": `"9090"`, "listenAddress": `"127.0.0.1"`},
+			migrated: true,
+		},
+		{
+			name:     "new key wins over legacy",
+			in:       `{"version": 2, "log_level": "debug", "logLevel": "warn"}`,
+			want:     map[string]string{"version": "2", "logLevel": `"warn"`},
+			migrated: true,
+		},
+		{
+			name: "current version",
+			in:   `{"version": 2, "logLevel": "info"}`,
+			want: map[string]string{"version": "2", "logLevel": `"info"`},
+		},
+		{
+			name: "newer version",
+			in:   `{"version": 3}`,
+			err:  "config version 3 is newer than supported version 2",
+		},
+		{
+			name: "bad version",
+			in:   `{"version": "two"}`,
+			err:  "invalid config version",
+		},
+	} {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tc.in), &raw); err != nil {
+			t.Fatal(err)
+		}
+		migrated, err := migrateConfig(raw)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: err = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		got := make(map[string]string)
+		for k, v := range raw {
+			got[k] = string(v)
+		}
+		if migrated != tc.migrated || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v (migrated %v), want %v (migrated %v)", tc.name, got, migrated, tc.want, tc.migrated)
+		}
+	}
+}
+
+func TestUnknownConfigKeys(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{`{"logLevel": "info", "listeners": []}`, nil},
+		{`{"loglevel": "info"}`, []string{`unknown key "loglevel" (did you mean "logLevel"?)`}},
+		{`{"NFQ-LOG-FILE": "/x"}`, []string{`unknown key "NFQ-LOG-FILE" (did you mean "nfq_log_file"?)`}},
+		{`{"colour": "red", "listen-port": "80"}`, []string{
+			`unknown key "colour"`,
+			`unknown key "listen-port" (did you mean "listenPort"?)`,
+		}},
+	} {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tc.in), &raw); err != nil {
+			t.Fatal(err)
+		}
+		if got := unknownConfigKeys(raw); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	valid := func() *AppConfig {
+		return &AppConfig{
+			NFQ_LOG_FILE:    filepath.Join(dir, "log.txt"),
+			NFQ_CONFIG_FILE: filepath.Join(dir, "config.json"),
+			NFQ_RULES_DIR:   filepath.Join(dir, "rules"),
+			// The engine's tmpfs does not have to exist yet.
+			NET_STATS_FILE: filepath.Join(dir, "missing/nfq.json"),
+			SYS_STATS_FILE: filepath.Join(dir, "missing/sys.json"),
+			Interface:      "lo",
+			LogLevel:       "info",
+			ListenPort:     "8080",
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(cfg *AppConfig)
+		want   []string
+	}{
+		{"valid", func(cfg *AppConfig) {}, nil},
+		{"relative stats file", func(cfg *AppConfig) { cfg.NET_STATS_FILE = "nfq.json" }, []string{`net_stats_file: "nfq.json" is not an absolute path`}},
+		{"missing log directory", func(cfg *AppConfig) { cfg.NFQ_LOG_FILE = filepath.Join(dir, "missing/log.txt") }, []string{"nfq_log_file: "}},
+		{"bad level and port", func(cfg *AppConfig) { cfg.LogLevel = "loud"; cfg.ListenPort = "http" }, []string{
+			`logLevel: "loud" is not one of debug, info, warn, error`,
+			`listenPort: "http" is not a valid port`,
+		}},
+		{"listeners skip port check", func(cfg *AppConfig) { cfg.ListenPort = ""; cfg.Listeners = []string{"unix:/run/gex.sock"} }, nil},
+		{"stale after", func(cfg *AppConfig) { cfg.StatsStaleAfter = "-5s" }, []string{`statsStaleAfter: "-5s" is not a positive duration`}},
+		{"socket mode", func(cfg *AppConfig) { cfg.SocketMode = "999" }, []string{"socketMode: "}},
+		{"disk percent", func(cfg *AppConfig) { cfg.DiskWarnPercent = 101 }, []string{"diskWarnPercent: "}},
+	} {
+		cfg := valid()
+		tc.modify(cfg)
+		err := validateConfig(cfg)
+		if tc.want == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			}
+			continue
+		}
+		var cerr *configError
+		if !errors.As(err, &cerr) || len(cerr.problems) != len(tc.want) {
+			t.Errorf("%s: got %v, want %d problems", tc.name, err, len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if !strings.HasPrefix(cerr.problems[i], want) {
+				t.Errorf("%s: problem %q, want prefix %q", tc.name, cerr.problems[i], want)
+			}
+		}
+	}
+}